@@ -2,17 +2,38 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"backend_msgs_golang/internal/acl"
+	"backend_msgs_golang/internal/captcha"
+	"backend_msgs_golang/internal/codegen"
+	"backend_msgs_golang/internal/events"
 	applog "backend_msgs_golang/internal/log"
+	"backend_msgs_golang/internal/metrics"
+	"backend_msgs_golang/internal/netutil"
+	"backend_msgs_golang/internal/oidc"
+	"backend_msgs_golang/internal/pow"
+	"backend_msgs_golang/internal/ratelimit"
+	"backend_msgs_golang/internal/reqid"
 	"backend_msgs_golang/internal/server"
+	"backend_msgs_golang/internal/storage"
 	redisstore "backend_msgs_golang/internal/storage/redis"
+	"backend_msgs_golang/internal/tenant"
+	"backend_msgs_golang/internal/tlsconf"
+	"backend_msgs_golang/internal/trace"
 
 	redis "github.com/redis/go-redis/v9"
 )
@@ -70,13 +91,81 @@ func envCSV(key string) []string {
 	return parts
 }
 
-func main() {
-	addr := os.Getenv("ADDR")
-	if addr == "" {
-		addr = ":8080"
+// newLogger builds the logger from LOG_* variables. The rotating file, when
+// LOG_OUTPUT=file, is returned so it can be reopened on SIGHUP.
+func newLogger() (*applog.JSONLogger, *applog.RotatingFile, error) {
+	levels := applog.NewLevels(applog.ParseLevel(os.Getenv("LOG_LEVEL")))
+	format, err := applog.ParseFormat(os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		return nil, nil, err
 	}
-	placeholderTTL := envDuration("PLACEHOLDER_TTL", 30*time.Minute)
-	messageTTL := envDuration("MESSAGE_TTL", 24*time.Hour)
+	var h slog.Handler
+	var rf *applog.RotatingFile
+	switch out := os.Getenv("LOG_OUTPUT"); out {
+	case "", "stderr":
+		h = applog.NewHandler(os.Stderr, format, levels)
+	case "stdout":
+		h = applog.NewHandler(os.Stdout, format, levels)
+	case "file":
+		path := os.Getenv("LOG_FILE")
+		if path == "" {
+			return nil, nil, errors.New("LOG_OUTPUT=file needs LOG_FILE")
+		}
+		if rf, err = applog.OpenRotating(path, envInt64("LOG_FILE_MAX_BYTES", 100<<20), int(envInt64("LOG_FILE_BACKUPS", 5))); err != nil {
+			return nil, nil, err
+		}
+		h = applog.NewHandler(rf, format, levels)
+	case "syslog":
+		tag := os.Getenv("LOG_SYSLOG_TAG")
+		if tag == "" {
+			tag = "backend-msgs"
+		}
+		if h, err = applog.NewSyslogHandler(os.Getenv("LOG_SYSLOG_NETWORK"), os.Getenv("LOG_SYSLOG_ADDR"), tag, format, levels); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown LOG_OUTPUT %q", out)
+	}
+	h = applog.NewSampler(h, applog.Sampling{
+		Window:     envDuration("LOG_SAMPLE_WINDOW", time.Second),
+		First:      int(envInt64("LOG_SAMPLE_FIRST", 0)),
+		Thereafter: int(envInt64("LOG_SAMPLE_THEREAFTER", 0)),
+	})
+	return applog.NewFromHandler(h, levels), rf, nil
+}
+
+// onSignal calls fn every time sig arrives, until ctx is done.
+func onSignal(ctx context.Context, sig os.Signal, fn func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+				fn()
+			}
+		}
+	}()
+}
+
+// codeOptions reads the CODE_* variables that shape generated codes. Unset
+// sizes stay zero so codegen.New picks each strategy's own default.
+func codeOptions(strategy string) codegen.Options {
+	return codegen.Options{
+		Strategy: strategy,
+		Length:   int(envInt64("CODE_LENGTH", 0)),
+		Alphabet: os.Getenv("CODE_ALPHABET"),
+		Words:    int(envInt64("CODE_WORDS", 0)),
+		Sep:      os.Getenv("CODE_WORD_SEP"),
+		Checksum: envBool("CODE_CHECKSUM", false),
+	}
+}
+
+// redisOptions reads the REDIS_* variables.
+func redisOptions() *redis.Options {
 	redisAddr := os.Getenv("REDIS_ADDR")
 	if redisAddr == "" {
 		redisAddr = "localhost:6379"
@@ -120,8 +209,26 @@ func main() {
 	if useTLS {
 		ropts.TLSConfig = &tls.Config{}
 	}
-	st := redisstore.NewWithOptions(ropts)
-	lg := applog.New(os.Getenv("LOG_LEVEL"))
+	return ropts
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(auditCommand(os.Args[2:]))
+	}
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	placeholderTTL := envDuration("PLACEHOLDER_TTL", 30*time.Minute)
+	messageTTL := envDuration("MESSAGE_TTL", 24*time.Hour)
+	st := redisstore.NewWithOptions(redisOptions())
+	lg, logFile, err := newLogger()
+	if err != nil {
+		applog.New("").Error("log_config_error", map[string]any{"error": err.Error()})
+		os.Exit(1)
+	}
+	slog.SetDefault(slog.New(lg.Handler()))
 	cfg := server.Config{
 		Addr:              addr,
 		PlaceholderTTL:    placeholderTTL,
@@ -131,7 +238,27 @@ func main() {
 		WriteTimeout:      envDuration("WRITE_TIMEOUT", 10*time.Second),
 		IdleTimeout:       envDuration("IDLE_TIMEOUT", 60*time.Second),
 		MaxBodyBytes:      envInt64("MAX_BODY_BYTES", 1<<20),
-		AllowedOrigins:    envCSV("CORS_ALLOW_ORIGINS"),
+		ChallengeTTL:      envDuration("CHALLENGE_TTL", time.Minute),
+		RevealMode:        envBool("REVEAL_MODE", false),
+		RevealNonce:       envBool("REVEAL_NONCE", false),
+		BatchMaxItems:     int(envInt64("BATCH_MAX_ITEMS", 100)),
+		BatchMaxBytes:     envInt64("BATCH_MAX_BYTES", 8<<20),
+		GuessLimit:        int(envInt64("GUESS_LIMIT", 0)),
+		GuessWindow:       envDuration("GUESS_WINDOW", 15*time.Minute),
+		GuessLockout:      envDuration("GUESS_LOCKOUT", 30*time.Second),
+		GuessMaxLockout:   envDuration("GUESS_MAX_LOCKOUT", time.Hour),
+		GuessBurnAfter:    int(envInt64("GUESS_BURN_AFTER", 0)),
+		GuessPrefixLen:    int(envInt64("GUESS_PREFIX_LEN", 0)),
+		ReaderBurnAfter:   int(envInt64("READER_BURN_AFTER", 3)),
+		Scan: storage.ScanPolicy{
+			NotFoundLimit: int(envInt64("SCAN_NOT_FOUND_LIMIT", 0)),
+			ConflictLimit: int(envInt64("SCAN_CONFLICT_LIMIT", 0)),
+			Window:        envDuration("SCAN_WINDOW", time.Minute),
+			Ban:           envDuration("SCAN_BAN", 15*time.Minute),
+			MaxBan:        envDuration("SCAN_MAX_BAN", 24*time.Hour),
+			OffenceWindow: envDuration("SCAN_OFFENCE_WINDOW", 7*24*time.Hour),
+		},
+		AllowedOrigins: envCSV("CORS_ALLOW_ORIGINS"),
 		RateLimitRPS: func() int {
 			v := os.Getenv("RATE_LIMIT_RPS")
 			if v == "" {
@@ -149,9 +276,342 @@ func main() {
 			return i
 		}(),
 	}
-	srv := server.New(cfg, st, lg)
+	codeStrategy := os.Getenv("CODE_STRATEGY")
+	if codeStrategy == "" {
+		codeStrategy = "alphabet"
+	}
+	var signingKeys map[byte][]byte
+	var activeKID byte
+	if codeStrategy == "signed" {
+		raw := os.Getenv("CODE_SIGNING_KEYS")
+		if f := os.Getenv("CODE_SIGNING_KEY_FILE"); f != "" {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				lg.Error("signing_key_file_error", map[string]any{"error": err.Error()})
+				os.Exit(1)
+			}
+			raw = string(b)
+		}
+		keys, kids, err := codegen.ParseKeys(raw)
+		if err != nil {
+			lg.Error("signing_keys_error", map[string]any{"error": err.Error()})
+			os.Exit(1)
+		}
+		signingKeys = keys
+		activeKID = kids[len(kids)-1]
+		if v := os.Getenv("CODE_SIGNING_KID"); v != "" {
+			if i, err := strconv.ParseUint(v, 10, 8); err == nil {
+				activeKID = byte(i)
+			}
+		}
+	}
+	opts := codeOptions(codeStrategy)
+	opts.Keys = signingKeys
+	opts.ActiveKID = activeKID
+	opts.TTL = placeholderTTL + messageTTL
+	codes, err := codegen.New(opts)
+	if err != nil {
+		lg.Error("code_generator_error", map[string]any{"error": err.Error()})
+		os.Exit(1)
+	}
+	lg.Info("code_generator", map[string]any{"strategy": codeStrategy, "entropy_bits": codes.Entropy()})
+	if e, ok := codes.(codegen.Expiring); ok && e.Lifetime() <= placeholderTTL {
+		// A reserved code would expire before a message could be attached.
+		lg.Error("code_generator_error", map[string]any{"error": "code lifetime must exceed PLACEHOLDER_TTL"})
+		os.Exit(1)
+	}
+	if codeStrategy == "digits" && cfg.GuessLimit <= 0 && cfg.GuessBurnAfter <= 0 {
+		// Short codes can be enumerated in minutes without a lockout.
+		lg.Error("code_generator_error", map[string]any{"error": "CODE_STRATEGY=digits requires GUESS_LIMIT or GUESS_BURN_AFTER"})
+		os.Exit(1)
+	}
+	cfg.Codes = codes
+	if envBool("BLOCK_PREVIEW_BOTS", true) {
+		cfg.PreviewBotAgents = envCSV("PREVIEW_BOT_AGENTS")
+		if cfg.PreviewBotAgents == nil {
+			cfg.PreviewBotAgents = server.DefaultPreviewBotAgents
+		}
+	}
+	cfg.RateLimitMaxKeys = int(envInt64("RATE_LIMIT_MAX_KEYS", 10000))
+	cfg.CreateRateRPS = int(envInt64("RATE_LIMIT_CREATE_RPS", 0))
+	cfg.CreateRateBurst = int(envInt64("RATE_LIMIT_CREATE_BURST", 0))
+	if os.Getenv("RATE_LIMIT_BACKEND") == "redis" {
+		cfg.Limiter = &ratelimit.Fallback{
+			Primary:   st.Limiter(),
+			Secondary: ratelimit.NewLocal(cfg.RateLimitMaxKeys),
+			OnError: func(err error) {
+				lg.Component("ratelimit").Warn("rate_limit_fallback", map[string]any{"error": err.Error()})
+			},
+		}
+	}
+	trusted, err := netutil.ParseCIDRs(envCSV("TRUSTED_PROXIES"))
+	if err != nil {
+		lg.Error("trusted_proxies_error", map[string]any{"error": err.Error()})
+		os.Exit(1)
+	}
+	cfg.TrustedProxies = trusted
+	cfg.RequestIDFrom = trusted
+	if v := envCSV("REQUEST_ID_TRUSTED"); len(v) > 0 {
+		if cfg.RequestIDFrom, err = netutil.ParseCIDRs(v); err != nil {
+			lg.Error("request_id_trusted_error", map[string]any{"error": err.Error()})
+			os.Exit(1)
+		}
+	}
+	cfg.RequestIDHeader = os.Getenv("REQUEST_ID_HEADER")
+	if cfg.RequestIDFormat, err = reqid.ParseFormat(os.Getenv("REQUEST_ID_FORMAT")); err != nil {
+		lg.Error("request_id_format_error", map[string]any{"error": err.Error()})
+		os.Exit(1)
+	}
+	lbs, err := netutil.ParseCIDRs(envCSV("PROXY_PROTOCOL_FROM"))
+	if err != nil {
+		lg.Error("proxy_protocol_error", map[string]any{"error": err.Error()})
+		os.Exit(1)
+	}
+	cfg.ProxyProtocolFrom = lbs
+	cfg.ProxyProtocolOptional = envBool("PROXY_PROTOCOL_OPTIONAL", false)
+	if envBool("POW_ENABLED", false) {
+		key, err := base64.StdEncoding.DecodeString(os.Getenv("POW_SECRET"))
+		if err != nil || len(key) < 16 {
+			key = make([]byte, 32)
+			rand.Read(key)
+			lg.Warn("pow_random_secret", map[string]any{"hint": "set POW_SECRET when running more than one instance"})
+		}
+		cfg.Pow = &pow.Issuer{
+			Key:       key,
+			TTL:       envDuration("POW_TTL", 2*time.Minute),
+			BaseBits:  int(envInt64("POW_BASE_BITS", 16)),
+			MaxBits:   int(envInt64("POW_MAX_BITS", 24)),
+			Threshold: float64(envInt64("POW_LOAD_THRESHOLD", 5)),
+		}
+	}
+	if p := os.Getenv("CAPTCHA_PROVIDER"); p != "" {
+		v, err := captcha.New(p, os.Getenv("CAPTCHA_SECRET"), os.Getenv("CAPTCHA_VERIFY_URL"))
+		if err != nil {
+			lg.Error("captcha_config_error", map[string]any{"error": err.Error()})
+			os.Exit(1)
+		}
+		cfg.Captcha = v
+		if v := os.Getenv("CAPTCHA_ABOVE_RATE"); v != "" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				cfg.CaptchaAboveRate = f
+			}
+		}
+	}
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+	if envBool("API_KEYS_ENABLED", false) {
+		cfg.Tenants = st
+		if envBool("ANONYMOUS_CREATE", true) {
+			cfg.AnonymousTenant = &tenant.Tenant{
+				ID:         "anonymous",
+				DailyQuota: envInt64("ANONYMOUS_DAILY_QUOTA", 0),
+			}
+		}
+	}
+	if iss := os.Getenv("OIDC_ISSUER"); iss != "" {
+		if os.Getenv("OIDC_AUDIENCE") == "" {
+			lg.Error("oidc_config_error", map[string]any{"error": "OIDC_ISSUER requires OIDC_AUDIENCE"})
+			os.Exit(1)
+		}
+		cfg.OIDC = &oidc.Verifier{
+			Issuer:         iss,
+			Audience:       os.Getenv("OIDC_AUDIENCE"),
+			JWKSURL:        os.Getenv("OIDC_JWKS_URL"),
+			GroupsClaim:    os.Getenv("OIDC_GROUPS_CLAIM"),
+			RequiredGroups: envCSV("OIDC_REQUIRED_GROUPS"),
+			CacheTTL:       envDuration("OIDC_JWKS_CACHE_TTL", time.Hour),
+			Leeway:         envDuration("OIDC_LEEWAY", 30*time.Second),
+		}
+	}
+	var certs *tlsconf.Reloader
+	if certFile := os.Getenv("TLS_CERT_FILE"); certFile != "" {
+		tc, rl, err := tlsconf.New(tlsconf.Options{
+			CertFile:   certFile,
+			KeyFile:    os.Getenv("TLS_KEY_FILE"),
+			MinVersion: os.Getenv("TLS_MIN_VERSION"),
+			Ciphers:    envCSV("TLS_CIPHERS"),
+			ClientCA:   os.Getenv("TLS_CLIENT_CA"),
+			ClientAuth: os.Getenv("TLS_CLIENT_AUTH"),
+		})
+		if err != nil {
+			lg.Error("tls_config_error", map[string]any{"error": err.Error()})
+			os.Exit(1)
+		}
+		cfg.TLS = tc
+		cfg.ClientCertCreators = envCSV("TLS_CLIENT_CREATORS")
+		certs = rl
+	}
+	aclFile := os.Getenv("ACL_FILE")
+	if aclFile != "" {
+		l, err := acl.LoadFile(aclFile, server.RouteClasses)
+		if err != nil {
+			lg.Error("acl_config_error", map[string]any{"error": err.Error()})
+			os.Exit(1)
+		}
+		cfg.ACL = l
+	} else {
+		p := acl.Policy{}
+		for _, class := range server.RouteClasses {
+			for _, action := range []string{"allow", "deny"} {
+				key := "ACL_" + strings.ToUpper(class+"_"+action)
+				cidrs := envCSV(key)
+				if len(cidrs) == 0 {
+					continue
+				}
+				if err := p.Add(action, class, cidrs, nil); err != nil {
+					lg.Error("acl_config_error", map[string]any{"var": key, "error": err.Error()})
+					os.Exit(1)
+				}
+			}
+		}
+		if len(p) > 0 {
+			cfg.ACL = acl.NewList(p)
+		}
+	}
+	sinks := events.Multi{events.LogSink{Log: lg}}
+	if stream := os.Getenv("EVENTS_STREAM"); stream != "" {
+		ss := st.EventStream(stream, envInt64("EVENTS_STREAM_MAXLEN", 10000))
+		ss.OnError = func(err error) {
+			lg.Component("storage").Error("event_stream_error", map[string]any{"error": err.Error()})
+		}
+		sinks = append(sinks, ss)
+	}
+	cfg.Events = sinks
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr != "" {
+		reg := metrics.NewRegistry()
+		reg.GaugeFunc("go_goroutines", "Number of goroutines.", func() float64 { return float64(runtime.NumGoroutine()) })
+		reg.GaugeFunc("placeholders_active", "Reserved codes still waiting for a ciphertext.", func() float64 {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			n, err := st.ActivePlaceholders(ctx)
+			if err != nil {
+				return -1
+			}
+			return float64(n)
+		})
+		cfg.Metrics = reg
+	}
+	cfg.AccessLog = envBool("ACCESS_LOG", true)
+	cfg.AccessLogSuccessRate = 1
+	if v := os.Getenv("ACCESS_LOG_SAMPLE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.AccessLogSuccessRate = f
+		}
+	}
+	var exporter *trace.OTLPExporter
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint == "" && base != "" {
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if endpoint != "" {
+		service := os.Getenv("OTEL_SERVICE_NAME")
+		if service == "" {
+			service = "backend-msgs"
+		}
+		exporter = trace.NewOTLPExporter(endpoint, service)
+		for _, kv := range envCSV("OTEL_EXPORTER_OTLP_HEADERS") {
+			if k, v, ok := strings.Cut(kv, "="); ok {
+				if exporter.Headers == nil {
+					exporter.Headers = map[string]string{}
+				}
+				exporter.Headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+		}
+		exporter.OnError = func(err error) { lg.Warn("trace_export_error", map[string]any{"error": err.Error()}) }
+		ratio := 1.0
+		if v := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); v != "" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				ratio = f
+			}
+		}
+		cfg.Tracer = &trace.Tracer{Exporter: exporter, Ratio: ratio}
+	}
+	if cfg.Audit, cfg.AuditKey, err = openAudit(st); err != nil {
+		lg.Error("audit_config_error", map[string]any{"error": err.Error()})
+		os.Exit(1)
+	}
+	cfg.StorageLog = lg.Component("storage")
+	cfg.RateLimitLog = lg.Component("ratelimit")
+	cfg.LogLevels = lg.Levels()
+	srv := server.New(cfg, st, lg.Component("server"))
+	st.SetObserver(srv.StorageObserver())
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
-	srv.Start(ctx)
+	if certs != nil {
+		logReload := func(err error) {
+			if err != nil {
+				lg.Error("tls_reload_error", map[string]any{"error": err.Error()})
+				return
+			}
+			lg.Info("tls_reloaded", nil)
+		}
+		go certs.Watch(ctx, envDuration("TLS_RELOAD_INTERVAL", 30*time.Second), logReload)
+		onSignal(ctx, syscall.SIGHUP, func() { logReload(certs.Reload()) })
+	}
+	if cfg.Audit != nil && envBool("AUDIT_EXPIRED", false) {
+		if err := st.EnableExpiryEvents(ctx); err != nil {
+			lg.Warn("audit_expiry_events_error", map[string]any{"error": err.Error()})
+		}
+		go func() {
+			if err := st.WatchExpired(ctx, func(code string) { srv.AuditExpired(ctx, code) }); err != nil {
+				lg.Error("audit_expiry_watch_error", map[string]any{"error": err.Error()})
+			}
+		}()
+	}
+	debugWindow := envDuration("LOG_DEBUG_WINDOW", 5*time.Minute)
+	onDebugSignal(ctx, func() {
+		lg.Levels().DebugFor(debugWindow)
+		lg.Info("log_debug_window", map[string]any{"seconds": int(debugWindow.Seconds())})
+	})
+	if logFile != nil {
+		onSignal(ctx, syscall.SIGHUP, func() {
+			if err := logFile.Reopen(); err != nil {
+				lg.Error("log_reopen_error", map[string]any{"error": err.Error()})
+			}
+		})
+	}
+	if cfg.ACL != nil && aclFile != "" {
+		// Lists from ACL_* variables are fixed for the life of the process.
+		onSignal(ctx, syscall.SIGHUP, func() {
+			if err := cfg.ACL.Reload(); err != nil {
+				lg.Error("acl_reload_error", map[string]any{"error": err.Error()})
+				return
+			}
+			lg.Info("acl_reloaded", nil)
+		})
+	}
+	if cfg.Metrics != nil {
+		go serveMetrics(ctx, metricsAddr, cfg.Metrics, lg)
+	}
+	exported := make(chan struct{})
+	if exporter != nil {
+		go func() { exporter.Run(ctx); close(exported) }()
+	} else {
+		close(exported)
+	}
+	err = srv.Start(ctx)
+	// stop the background workers and flush pending spans before exiting
+	cancel()
+	<-exported
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		lg.Error("listen_error", map[string]any{"addr": addr, "error": err.Error()})
+		os.Exit(1)
+	}
+}
+
+// serveMetrics exposes /metrics on its own listener so it can stay off the
+// public interface.
+func serveMetrics(ctx context.Context, addr string, reg *metrics.Registry, lg applog.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg.Handler())
+	ms := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		ms.Close()
+	}()
+	lg.Info("metrics_listening", map[string]any{"addr": addr})
+	if err := ms.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		lg.Error("metrics_listen_error", map[string]any{"error": err.Error()})
+	}
 }
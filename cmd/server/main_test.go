@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"backend_msgs_golang/internal/codegen"
+)
+
+func TestCodeOptionsDefaults(t *testing.T) {
+	t.Setenv("CODE_LENGTH", "")
+	for strategy, want := range map[string]int{"alphabet": 8, "digits": 6} {
+		g, err := codegen.New(codeOptions(strategy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := g.Generate()
+		if len(code) != want {
+			t.Fatalf("%s: expected %d characters, got %q", strategy, want, code)
+		}
+	}
+}
+
+func TestCodeOptionsLength(t *testing.T) {
+	t.Setenv("CODE_LENGTH", "10")
+	g, err := codegen.New(codeOptions("digits"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := g.Generate(); len(code) != 10 {
+		t.Fatalf("expected 10 digits, got %q", code)
+	}
+}
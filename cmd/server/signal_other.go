@@ -0,0 +1,8 @@
+//go:build windows || plan9
+
+package main
+
+import "context"
+
+// onDebugSignal does nothing where SIGUSR1 does not exist.
+func onDebugSignal(ctx context.Context, fn func()) {}
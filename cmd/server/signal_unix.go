@@ -0,0 +1,13 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"syscall"
+)
+
+// onDebugSignal calls fn on every SIGUSR1.
+func onDebugSignal(ctx context.Context, fn func()) {
+	onSignal(ctx, syscall.SIGUSR1, fn)
+}
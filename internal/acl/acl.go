@@ -0,0 +1,147 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"backend_msgs_golang/internal/netutil"
+)
+
+// Rules is the allow/deny pair for one route class. A denied address is
+// always refused; a non-empty allowlist admits only its members.
+type Rules struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+func (r Rules) Allowed(ip net.IP) bool {
+	if netutil.Contains(r.Deny, ip) {
+		return false
+	}
+	return len(r.Allow) == 0 || netutil.Contains(r.Allow, ip)
+}
+
+// Policy holds the rules per route class ("create", "read", "admin").
+type Policy map[string]Rules
+
+func (p Policy) Allowed(class string, ip net.IP) bool {
+	return p[class].Allowed(ip)
+}
+
+// Add parses cidrs into the allow or deny list of class; class "*" applies
+// to every class in classes.
+func (p Policy) Add(action, class string, cidrs []string, classes []string) error {
+	nets, err := netutil.ParseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	targets := []string{class}
+	if class == "*" {
+		targets = classes
+	}
+	for _, c := range targets {
+		r := p[c]
+		switch action {
+		case "allow":
+			r.Allow = append(r.Allow, nets...)
+		case "deny":
+			r.Deny = append(r.Deny, nets...)
+		default:
+			return fmt.Errorf("acl: unknown action %q", action)
+		}
+		p[c] = r
+	}
+	return nil
+}
+
+// Parse reads one rule per line, "allow|deny <class|*> <cidr>[,<cidr>...]",
+// with '#' comments:
+//
+//	allow create 10.0.0.0/8
+//	deny  *      203.0.113.0/24
+func Parse(rd io.Reader, classes []string) (Policy, error) {
+	p := Policy{}
+	sc := bufio.NewScanner(rd)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			return nil, fmt.Errorf("acl: line %d: want \"allow|deny class cidr\"", n)
+		}
+		if f[1] != "*" && !contains(classes, f[1]) {
+			return nil, fmt.Errorf("acl: line %d: unknown class %q", n, f[1])
+		}
+		if err := p.Add(f[0], f[1], strings.Split(f[2], ","), classes); err != nil {
+			return nil, fmt.Errorf("acl: line %d: %w", n, err)
+		}
+	}
+	return p, sc.Err()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// List is a Policy that can be swapped while requests are being checked.
+type List struct {
+	path    string
+	classes []string
+	p       atomic.Pointer[Policy]
+}
+
+func NewList(p Policy) *List {
+	l := &List{}
+	l.Set(p)
+	return l
+}
+
+// LoadFile reads the policy from path; Reload reads it again.
+func LoadFile(path string, classes []string) (*List, error) {
+	l := &List{path: path, classes: classes}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload re-reads the file. On error the current policy stays in force.
+// A List built with NewList has no file and keeps its policy.
+func (l *List) Reload() error {
+	if l.path == "" {
+		return nil
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p, err := Parse(f, l.classes)
+	if err != nil {
+		return err
+	}
+	l.Set(p)
+	return nil
+}
+
+func (l *List) Set(p Policy) { l.p.Store(&p) }
+
+func (l *List) Allowed(class string, ip net.IP) bool {
+	p := l.p.Load()
+	return p == nil || p.Allowed(class, ip)
+}
@@ -0,0 +1,64 @@
+package acl
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var classes = []string{"create", "read"}
+
+func TestParseAndCheck(t *testing.T) {
+	p, err := Parse(strings.NewReader(`
+# corporate network only for creation
+allow create 10.0.0.0/8,192.168.1.7
+deny  *      10.66.0.0/16   # abusive range
+`), classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		class, ip string
+		want      bool
+	}{
+		{"create", "10.1.2.3", true},
+		{"create", "192.168.1.7", true},
+		{"create", "8.8.8.8", false},
+		{"create", "10.66.1.1", false},
+		{"read", "8.8.8.8", true},
+		{"read", "10.66.1.1", false},
+	}
+	for _, c := range cases {
+		if got := p.Allowed(c.class, net.ParseIP(c.ip)); got != c.want {
+			t.Fatalf("%s %s: got %v, want %v", c.class, c.ip, got, c.want)
+		}
+	}
+	for _, bad := range []string{"allow create", "permit read 10.0.0.0/8", "allow write 10.0.0.0/8", "deny read 10.0.0.300/8"} {
+		if _, err := Parse(strings.NewReader(bad), classes); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestListReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl")
+	os.WriteFile(path, []byte("deny read 203.0.113.0/24\n"), 0o600)
+	l, err := LoadFile(path, classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("203.0.113.9")
+	if l.Allowed("read", ip) {
+		t.Fatalf("expected deny before reload")
+	}
+	os.WriteFile(path, []byte("garbage\n"), 0o600)
+	if err := l.Reload(); err == nil || l.Allowed("read", ip) {
+		t.Fatalf("a broken file must keep the current policy")
+	}
+	os.WriteFile(path, []byte("# empty\n"), 0o600)
+	if err := l.Reload(); err != nil || !l.Allowed("read", ip) {
+		t.Fatalf("expected allow after reload, got %v", err)
+	}
+}
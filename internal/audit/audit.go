@@ -0,0 +1,119 @@
+// Package audit keeps a tamper-evident record of message lifecycle events.
+// Each entry carries the hash of the one before it, so editing, inserting or
+// removing an entry breaks the chain from that point on. Codes and client
+// IPs are stored only as keyed hashes: the server never records a code, and
+// whoever holds the key can still find the entries for a given one.
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Event types.
+const (
+	Reserved = "reserved" // POST /code
+	Created  = "created"  // ciphertext stored
+	Read     = "read"     // ciphertext delivered and burned
+	Burned   = "burned"   // destroyed without being delivered
+	Expired  = "expired"  // removed by its TTL
+)
+
+// Genesis is the Prev of the first entry.
+const Genesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+type Entry struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	CodeHash   string    `json:"code_hash"`
+	ClientHash string    `json:"client_hash,omitempty"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	// PrefixHash and Count describe a burn of every code under a prefix,
+	// in place of CodeHash. Both are omitted otherwise, so older entries
+	// keep their hashes.
+	PrefixHash string `json:"prefix_hash,omitempty"`
+	Count      int    `json:"count,omitempty"`
+	Prev       string `json:"prev"`
+	Hash       string `json:"hash"`
+}
+
+// Link places e after the entry with sequence number seq-1 and hash prev,
+// and seals it.
+func (e *Entry) Link(seq uint64, prev string) {
+	e.Seq, e.Prev = seq, prev
+	e.Hash = e.digest()
+}
+
+// digest hashes the entry's JSON encoding with Hash left empty; field order
+// is fixed by the struct, so the encoding is canonical.
+func (e Entry) digest() string {
+	e.Hash = ""
+	e.Time = e.Time.UTC()
+	b, _ := json.Marshal(e)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Replayer calls fn with every entry, oldest first.
+type Replayer interface {
+	Each(ctx context.Context, fn func(Entry) error) error
+}
+
+// Store appends sealed entries and replays them. Append must chain e to the
+// current head atomically, calling e.Link with the next sequence number and
+// the head's hash.
+type Store interface {
+	Replayer
+	Append(ctx context.Context, e *Entry) error
+}
+
+// Hasher derives the keyed hashes stored in place of codes and client IPs.
+type Hasher struct {
+	Key []byte
+}
+
+func (h Hasher) Sum(kind, value string) string {
+	if value == "" {
+		return ""
+	}
+	m := hmac.New(sha256.New, h.Key)
+	m.Write([]byte(kind))
+	m.Write([]byte{0})
+	m.Write([]byte(value))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func (h Hasher) Code(code string) string { return h.Sum("code", code) }
+func (h Hasher) Client(ip string) string { return h.Sum("client", ip) }
+func (h Hasher) Prefix(p string) string  { return h.Sum("prefix", p) }
+
+var ErrBroken = errors.New("audit: chain broken")
+
+// Verify replays the store and checks every entry's sequence number, link
+// and hash. It returns the number of entries and the head hash, which can
+// be compared with a copy kept elsewhere to detect a truncated tail.
+func Verify(ctx context.Context, st Replayer) (n uint64, head string, err error) {
+	head = Genesis
+	err = st.Each(ctx, func(e Entry) error {
+		switch {
+		case e.Seq != n+1:
+			return fmt.Errorf("%w: entry %d follows %d", ErrBroken, e.Seq, n)
+		case e.Prev != head:
+			return fmt.Errorf("%w: entry %d does not link to its predecessor", ErrBroken, e.Seq)
+		case e.digest() != e.Hash:
+			return fmt.Errorf("%w: entry %d was modified", ErrBroken, e.Seq)
+		}
+		n, head = e.Seq, e.Hash
+		return nil
+	})
+	return n, head, err
+}
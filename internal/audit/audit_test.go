@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func appendN(t *testing.T, st Store, h Hasher, events ...string) {
+	t.Helper()
+	for i, ev := range events {
+		e := &Entry{Time: time.Now(), Event: ev, CodeHash: h.Code("code" + string(rune('a'+i))), ClientHash: h.Client("203.0.113.7"), Tenant: "acme"}
+		if err := st.Append(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	h := Hasher{Key: []byte("k")}
+	af, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, af, h, Created, Read)
+	af.Close()
+
+	af, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, af, h, Expired)
+	af.Close()
+	n, head, err := Verify(context.Background(), af)
+	if err != nil || n != 3 || head == Genesis {
+		t.Fatalf("expected an intact chain of 3, got %d %s %v", n, head, err)
+	}
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "codea") || strings.Contains(string(raw), "203.0.113.7") {
+		t.Fatalf("codes and IPs must only be stored hashed")
+	}
+
+	lines := strings.SplitAfter(strings.TrimSuffix(string(raw), "\n"), "\n")
+	tampered := map[string]string{
+		"edited":    strings.Join([]string{lines[0], strings.Replace(lines[1], `"read"`, `"burned"`, 1), lines[2]}, ""),
+		"removed":   lines[0] + lines[2],
+		"reordered": lines[1] + lines[0] + lines[2],
+		"garbage":   lines[0] + "not json\n",
+	}
+	for name, content := range tampered {
+		os.WriteFile(path, []byte(content), 0o600)
+		if _, _, err := Verify(context.Background(), LogFile(path)); !errors.Is(err, ErrBroken) {
+			t.Fatalf("%s: expected a broken chain, got %v", name, err)
+		}
+	}
+}
+
+func TestHasher(t *testing.T) {
+	a, b := Hasher{Key: []byte("k1")}, Hasher{Key: []byte("k2")}
+	if a.Code("x") == b.Code("x") || a.Code("x") != a.Code("x") || a.Code("x") == a.Client("x") || a.Code("") != "" {
+		t.Fatalf("unexpected hashes")
+	}
+}
@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is a Store writing one JSON entry per line to an append-only file.
+// Only one process may append to a file.
+type File struct {
+	// Sync flushes every entry to disk before Append returns.
+	Sync bool
+
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	seq  uint64
+	head string
+}
+
+// OpenFile opens or creates path and resumes the chain from its last entry.
+func OpenFile(path string) (*File, error) {
+	af := &File{path: path, head: Genesis}
+	err := af.Each(context.Background(), func(e Entry) error {
+		af.seq, af.head = e.Seq, e.Hash
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	af.f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return af, nil
+}
+
+func (af *File) Append(_ context.Context, e *Entry) error {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	e.Link(af.seq+1, af.head)
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := af.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if af.Sync {
+		if err := af.f.Sync(); err != nil {
+			return err
+		}
+	}
+	af.seq, af.head = e.Seq, e.Hash
+	return nil
+}
+
+func (af *File) Each(ctx context.Context, fn func(Entry) error) error {
+	return LogFile(af.path).Each(ctx, fn)
+}
+
+// LogFile replays a file written by File without opening it for writing.
+type LogFile string
+
+func (path LogFile) Each(ctx context.Context, fn func(Entry) error) error {
+	f, err := os.Open(string(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("%w: line %d is not an entry", ErrBroken, line)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func (af *File) Close() error {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	return af.f.Close()
+}
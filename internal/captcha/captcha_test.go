@@ -0,0 +1,66 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func stub(t *testing.T, body map[string]any) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("secret") != "s3cret" {
+			t.Errorf("secret not sent")
+		}
+		if r.PostForm.Get("response") == "good" {
+			json.NewEncoder(w).Encode(body)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "error-codes": []string{"invalid-input-response"}})
+	}))
+}
+
+func TestProviders(t *testing.T) {
+	srv := stub(t, map[string]any{"success": true})
+	defer srv.Close()
+	for _, p := range []string{"hcaptcha", "turnstile", "recaptcha"} {
+		v, err := New(p, "s3cret", srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Counted{Verifier: v}
+		if err := c.Verify(context.Background(), "good", "1.2.3.4"); err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		if err := c.Verify(context.Background(), "bad", "1.2.3.4"); !errors.Is(err, ErrFailed) {
+			t.Fatalf("%s: expected ErrFailed, got %v", p, err)
+		}
+		if c.Passed.Load() != 1 || c.Failed.Load() != 1 {
+			t.Fatalf("%s: unexpected counters", p)
+		}
+	}
+}
+
+func TestReCaptchaScore(t *testing.T) {
+	srv := stub(t, map[string]any{"success": true, "score": 0.1})
+	defer srv.Close()
+	v := &ReCaptcha{Secret: "s3cret", URL: srv.URL, MinScore: 0.5}
+	if err := v.Verify(context.Background(), "good", ""); !errors.Is(err, ErrFailed) {
+		t.Fatalf("expected low score to fail, got %v", err)
+	}
+}
+
+func TestProviderDown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	c := &Counted{Verifier: &Turnstile{Secret: "s3cret", URL: srv.URL}}
+	err := c.Verify(context.Background(), "good", "")
+	if err == nil || errors.Is(err, ErrFailed) || c.Errors.Load() != 1 {
+		t.Fatalf("expected transport error, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package codegen
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+	Digits          = "0123456789"
+)
+
+type Generator interface {
+	Generate() (string, error)
+	// Entropy is the number of random bits in each code.
+	Entropy() float64
+}
+
+// Checker is implemented by generators whose codes carry redundancy, so a
+// mistyped code can be rejected before any storage lookup.
+type Checker interface {
+	Check(code string) bool
+}
+
+// Expiring is implemented by generators whose codes carry their own expiry;
+// a code stops verifying Lifetime after it was generated, however long the
+// message stored under it lives.
+type Expiring interface {
+	Lifetime() time.Duration
+}
+
+type Options struct {
+	Strategy string
+	Length   int
+	Alphabet string
+	Words    int
+	Sep      string
+	Checksum bool
+	// Signed strategy: keys by kid, the kid used for new codes and how long
+	// a code stays valid.
+	Keys      map[byte][]byte
+	ActiveKID byte
+	TTL       time.Duration
+}
+
+// New builds the generator described by opts. Strategy is "alphabet"
+// (default), "digits" for short human-typable codes, "words", or "signed".
+func New(opts Options) (Generator, error) {
+	var g Generator
+	switch strings.ToLower(opts.Strategy) {
+	case "", "alphabet":
+		a := Alphabet{Length: opts.Length, Chars: opts.Alphabet}
+		if a.Length <= 0 {
+			a.Length = 8
+		}
+		if a.Chars == "" {
+			a.Chars = DefaultAlphabet
+		}
+		if len(a.Chars) < 2 {
+			return nil, errors.New("codegen: alphabet needs at least 2 characters")
+		}
+		g = a
+	case "digits":
+		a := Alphabet{Length: opts.Length, Chars: Digits}
+		if a.Length <= 0 {
+			a.Length = 6
+		}
+		g = a
+	case "words":
+		w := Words{Count: opts.Words, List: WordList, Sep: opts.Sep}
+		if w.Count <= 0 {
+			// 48 bits, on par with the default alphabet code
+			w.Count = 6
+		}
+		if w.Sep == "" {
+			w.Sep = "-"
+		}
+		g = w
+	case "signed":
+		if _, ok := opts.Keys[opts.ActiveKID]; !ok {
+			return nil, fmt.Errorf("codegen: no signing key for kid %d", opts.ActiveKID)
+		}
+		if opts.TTL <= 0 {
+			return nil, errors.New("codegen: signed codes need a TTL")
+		}
+		// the MAC already rejects typos
+		return Signed{Keys: opts.Keys, ActiveKID: opts.ActiveKID, TTL: opts.TTL}, nil
+	default:
+		return nil, fmt.Errorf("codegen: unknown strategy %q", opts.Strategy)
+	}
+	if opts.Checksum {
+		c := Checksum{Inner: g, Chars: DefaultAlphabet}
+		if a, ok := g.(Alphabet); ok {
+			c.Chars = a.Chars
+		}
+		if w, ok := g.(Words); ok {
+			c.Sep = w.Sep
+		}
+		g = c
+	}
+	return g, nil
+}
+
+type Alphabet struct {
+	Length int
+	Chars  string
+}
+
+func (a Alphabet) Generate() (string, error) {
+	max := big.NewInt(int64(len(a.Chars)))
+	var b strings.Builder
+	for i := 0; i < a.Length; i++ {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(a.Chars[idx.Int64()])
+	}
+	return b.String(), nil
+}
+
+func (a Alphabet) Entropy() float64 {
+	return float64(a.Length) * math.Log2(float64(len(a.Chars)))
+}
+
+// Words builds diceware-style codes such as "lake-moon-tide-fork".
+type Words struct {
+	Count int
+	List  []string
+	Sep   string
+}
+
+func (w Words) Generate() (string, error) {
+	max := big.NewInt(int64(len(w.List)))
+	parts := make([]string, w.Count)
+	for i := range parts {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = w.List[idx.Int64()]
+	}
+	return strings.Join(parts, w.Sep), nil
+}
+
+func (w Words) Entropy() float64 {
+	return float64(w.Count) * math.Log2(float64(len(w.List)))
+}
+
+// Checksum appends a Luhn mod N check character, drawn from Chars, to the
+// codes of Inner. It catches every single-character typo between characters
+// of Chars and most adjacent transpositions; characters outside Chars (word
+// letters missing from the alphabet, separators) are folded into it modulo N,
+// so a typo that involves them is only caught most of the time.
+type Checksum struct {
+	Inner Generator
+	Chars string
+	Sep   string
+}
+
+func (c Checksum) Generate() (string, error) {
+	code, err := c.Inner.Generate()
+	if err != nil {
+		return "", err
+	}
+	return code + c.Sep + string(c.checkChar(code)), nil
+}
+
+func (c Checksum) Entropy() float64 { return c.Inner.Entropy() }
+
+func (c Checksum) Check(code string) bool {
+	if len(code) < len(c.Sep)+2 {
+		return false
+	}
+	body := code[:len(code)-1-len(c.Sep)]
+	if code[len(body):len(code)-1] != c.Sep {
+		return false
+	}
+	return code[len(code)-1] == c.checkChar(body)
+}
+
+func (c Checksum) checkChar(s string) byte {
+	n := len(c.Chars)
+	factor, sum := 2, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		cp := strings.IndexByte(c.Chars, s[i])
+		if cp < 0 {
+			cp = int(s[i]) % n
+		}
+		addend := factor * cp
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+		sum += addend/n + addend%n
+	}
+	return c.Chars[(n-sum%n)%n]
+}
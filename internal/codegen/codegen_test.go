@@ -0,0 +1,111 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlphabetDefault(t *testing.T) {
+	g, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := g.Generate()
+	if err != nil || len(code) != 8 {
+		t.Fatalf("unexpected code %q: %v", code, err)
+	}
+	if e := g.Entropy(); e < 46 || e > 47 {
+		t.Fatalf("unexpected entropy %.2f", e)
+	}
+}
+
+func TestWords(t *testing.T) {
+	g, err := New(Options{Strategy: "words", Words: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := g.Generate()
+	if n := len(strings.Split(code, "-")); n != 5 {
+		t.Fatalf("expected 5 words, got %q", code)
+	}
+	if g.Entropy() != 40 {
+		t.Fatalf("unexpected entropy %.2f", g.Entropy())
+	}
+}
+
+func TestWordsDefault(t *testing.T) {
+	g, err := New(Options{Strategy: "words"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Entropy() < 46 {
+		t.Fatalf("default words entropy %.2f below the alphabet default", g.Entropy())
+	}
+}
+
+func TestChecksumCatchesTypos(t *testing.T) {
+	for _, opts := range []Options{{Checksum: true}, {Strategy: "words", Checksum: true}} {
+		g, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := g.(Checker)
+		for i := 0; i < 50; i++ {
+			code, _ := g.Generate()
+			if !c.Check(code) {
+				t.Fatalf("valid code rejected: %q", code)
+			}
+			b := []byte(code)
+			if b[0] == 'A' {
+				b[0] = 'B'
+			} else {
+				b[0] = 'A'
+			}
+			if c.Check(string(b)) {
+				t.Fatalf("typo accepted: %q -> %q", code, b)
+			}
+		}
+	}
+}
+
+func TestUnknownStrategy(t *testing.T) {
+	if _, err := New(Options{Strategy: "emoji"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestSignedCodes(t *testing.T) {
+	keys, kids, err := ParseKeys("1:MDEyMzQ1Njc4OWFiY2RlZg==\n# old key\n0:ZmVkY2JhOTg3NjU0MzIxMA==")
+	if err != nil || len(kids) != 2 {
+		t.Fatalf("parse keys: %v %v", kids, err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	g := Signed{Keys: keys, ActiveKID: 1, TTL: time.Hour, Now: func() time.Time { return now }}
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Check(code) {
+		t.Fatalf("valid code rejected: %q", code)
+	}
+	b := []byte(code)
+	if b[3] == 'A' {
+		b[3] = 'B'
+	} else {
+		b[3] = 'A'
+	}
+	if g.Check(string(b)) {
+		t.Fatalf("forged code accepted")
+	}
+
+	rotated := Signed{Keys: map[byte][]byte{2: []byte("another-key-0123")}, ActiveKID: 2, TTL: time.Hour, Now: g.Now}
+	if rotated.Check(code) {
+		t.Fatalf("code accepted after its key was removed")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if g.Check(code) {
+		t.Fatalf("expired code accepted")
+	}
+}
@@ -0,0 +1,38 @@
+package codegen
+
+// WordList is a 256-entry list of short, distinct English words, so each word
+// carries exactly 8 bits and is easy to say over the phone.
+var WordList = []string{
+	"able", "acid", "aged", "also", "area", "army", "away", "baby",
+	"back", "ball", "band", "bank", "base", "bath", "bear", "beat",
+	"bell", "belt", "best", "bird", "blow", "blue", "boat", "body",
+	"bone", "book", "boot", "born", "boss", "both", "bowl", "bulk",
+	"burn", "bush", "busy", "cafe", "cake", "calm", "came", "camp",
+	"card", "care", "cart", "case", "cash", "cast", "cell", "chef",
+	"chip", "city", "clay", "club", "coal", "coat", "code", "cold",
+	"cook", "cool", "copy", "corn", "cost", "crew", "crop", "dark",
+	"data", "date", "dawn", "deal", "dear", "deep", "desk", "dial",
+	"diet", "disk", "dock", "door", "down", "draw", "drop", "drum",
+	"duck", "dust", "duty", "each", "earn", "east", "easy", "edge",
+	"else", "even", "ever", "exam", "face", "fact", "fair", "fall",
+	"farm", "fast", "fear", "feed", "feel", "film", "find", "fine",
+	"fire", "firm", "fish", "five", "flag", "flat", "flow", "folk",
+	"food", "foot", "form", "fort", "four", "free", "frog", "fuel",
+	"full", "fund", "gain", "game", "gate", "gear", "gift", "girl",
+	"give", "glad", "goal", "gold", "golf", "gone", "good", "gray",
+	"grew", "grid", "grow", "gulf", "hair", "half", "hall", "hand",
+	"hard", "harm", "head", "heat", "held", "help", "herb", "hero",
+	"hill", "hint", "hold", "home", "hope", "horn", "host", "hour",
+	"huge", "idea", "inch", "iron", "item", "jazz", "join", "joke",
+	"jump", "jury", "keen", "keep", "kick", "kind", "king", "kite",
+	"knee", "knot", "lake", "lamp", "land", "lane", "last", "late",
+	"lawn", "lead", "leaf", "lean", "left", "lens", "life", "lift",
+	"like", "lime", "line", "link", "lion", "list", "live", "load",
+	"loan", "lock", "long", "look", "loop", "lord", "loud", "love",
+	"luck", "lung", "mail", "main", "make", "mall", "many", "mark",
+	"mask", "meal", "meat", "menu", "mild", "milk", "mind", "mine",
+	"mint", "miss", "mode", "moon", "more", "most", "move", "much",
+	"nail", "name", "navy", "near", "neat", "neck", "need", "nest",
+	"news", "next", "nice", "nine", "noon", "nose", "note", "oval",
+	"oven", "pack", "page", "pair", "palm", "park", "part", "past",
+}
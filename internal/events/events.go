@@ -0,0 +1,53 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	applog "backend_msgs_golang/internal/log"
+)
+
+// Event is a security-relevant occurrence other systems may act on, such as
+// a client being banned. Fields never carry codes or ciphertext.
+type Event struct {
+	Type   string         `json:"type"`
+	Time   time.Time      `json:"time"`
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+func New(typ string, fields map[string]any) Event {
+	return Event{Type: typ, Time: time.Now().UTC(), Fields: fields}
+}
+
+// Sink receives events. Emit must not block the request for long; sinks
+// that talk to the network should bound their own time.
+type Sink interface {
+	Emit(ctx context.Context, e Event)
+}
+
+// LogSink writes events as warning log lines tagged with "event".
+type LogSink struct {
+	Log applog.Logger
+}
+
+func (s LogSink) Emit(_ context.Context, e Event) {
+	fields := map[string]any{"event": e.Type}
+	for k, v := range e.Fields {
+		fields[k] = v
+	}
+	s.Log.Warn(e.Type, fields)
+}
+
+// Multi sends every event to each of its sinks in order.
+type Multi []Sink
+
+func (m Multi) Emit(ctx context.Context, e Event) {
+	for _, s := range m {
+		s.Emit(ctx, e)
+	}
+}
+
+// Func adapts a function to Sink.
+type Func func(ctx context.Context, e Event)
+
+func (f Func) Emit(ctx context.Context, e Event) { f(ctx, e) }
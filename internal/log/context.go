@@ -0,0 +1,19 @@
+package log
+
+import "context"
+
+type ctxKey struct{}
+
+// NewContext returns a context carrying l, typically a request's logger
+// built with With.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger stored in ctx, or fallback.
+func FromContext(ctx context.Context, fallback Logger) Logger {
+	if l, ok := ctx.Value(ctxKey{}).(Logger); ok {
+		return l
+	}
+	return fallback
+}
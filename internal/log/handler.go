@@ -0,0 +1,46 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// NewHandler returns a slog handler writing one line per record to w, as
+// JSON or as logfmt ("key=value"), with the keys JSONLogger always used:
+// ts (UTC, RFC 3339), level (lower case) and msg.
+func NewHandler(w io.Writer, format string, level slog.Leveler) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceAttr}
+	if format == "logfmt" {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+// ParseFormat checks a LOG_FORMAT value; "" means json.
+func ParseFormat(s string) (string, error) {
+	switch f := strings.ToLower(s); f {
+	case "", "json":
+		return "json", nil
+	case "logfmt", "text":
+		return "logfmt", nil
+	}
+	return "", fmt.Errorf("log: unknown format %q", s)
+}
+
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		return slog.String("ts", a.Value.Time().UTC().Format(time.RFC3339Nano))
+	case slog.LevelKey:
+		if lv, ok := a.Value.Any().(slog.Level); ok {
+			return slog.String("level", strings.ToLower(lv.String()))
+		}
+	}
+	return a
+}
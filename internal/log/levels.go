@@ -0,0 +1,157 @@
+package log
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Levels holds the base level and per-component overrides of a logger
+// tree. Everything can change at runtime; reads are lock-free.
+type Levels struct {
+	base      atomic.Int32
+	overrides atomic.Pointer[map[string]Level]
+	boosted   atomic.Bool // a DebugFor window is running
+
+	mu      sync.Mutex // serialises writers
+	boost   *time.Timer
+	restore Level
+	until   time.Time
+}
+
+func NewLevels(base Level) *Levels {
+	l := &Levels{}
+	l.base.Store(int32(base))
+	l.overrides.Store(&map[string]Level{})
+	return l
+}
+
+func (l *Levels) Base() Level { return Level(l.base.Load()) }
+
+func (l *Levels) SetBase(lv Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopBoost()
+	l.base.Store(int32(lv))
+}
+
+// Effective is the level a component logs at: Debug during a DebugFor
+// window, else its override, else the base.
+func (l *Levels) Effective(component string) Level {
+	if l.boosted.Load() {
+		return Debug
+	}
+	if lv, ok := (*l.overrides.Load())[component]; ok && component != "" {
+		return lv
+	}
+	return l.Base()
+}
+
+func (l *Levels) Overrides() map[string]Level {
+	m := map[string]Level{}
+	for k, v := range *l.overrides.Load() {
+		m[k] = v
+	}
+	return m
+}
+
+// SetComponent overrides one component's level; ClearComponent undoes it.
+func (l *Levels) SetComponent(component string, lv Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m := l.Overrides()
+	m[component] = lv
+	l.overrides.Store(&m)
+}
+
+func (l *Levels) ClearComponent(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m := l.Overrides()
+	delete(m, component)
+	l.overrides.Store(&m)
+}
+
+// DebugFor lowers the base level and every override to Debug for d, then
+// restores the levels they had before. Calling it again during the window restarts the window; SetBase
+// ends it early and keeps the new level.
+func (l *Levels) DebugFor(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.boost != nil {
+		l.boost.Stop()
+	} else {
+		l.restore = l.Base()
+	}
+	l.base.Store(int32(Debug))
+	l.boosted.Store(true)
+	l.until = time.Now().Add(d)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.boost == t {
+			l.boost = nil
+			l.boosted.Store(false)
+			l.base.Store(int32(l.restore))
+		}
+	})
+	l.boost = t
+}
+
+// DebugUntil reports when the current DebugFor window ends, or the zero
+// time when none is running.
+func (l *Levels) DebugUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.boost == nil {
+		return time.Time{}
+	}
+	return l.until
+}
+
+func (l *Levels) stopBoost() {
+	if l.boost != nil {
+		l.boost.Stop()
+		l.boost = nil
+		l.boosted.Store(false)
+	}
+}
+
+// Level reports the most verbose level any component logs at, so a handler
+// shared by the tree lets through whatever a JSONLogger has already allowed.
+func (l *Levels) Level() slog.Level {
+	min := l.Base()
+	for _, lv := range *l.overrides.Load() {
+		if lv < min {
+			min = lv
+		}
+	}
+	return min.Slog()
+}
+
+func (lv Level) String() string {
+	switch lv {
+	case Debug:
+		return "debug"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseLevelStrict is ParseLevel for untrusted input: it refuses unknown
+// names instead of falling back to Info.
+func ParseLevelStrict(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug", "info", "warn", "error":
+		return ParseLevel(s), nil
+	}
+	return Info, fmt.Errorf("log: unknown level %q", s)
+}
@@ -1,8 +1,10 @@
 package log
 
 import (
-    "encoding/json"
+    "context"
+    "log/slog"
     "os"
+    "sort"
     "strings"
     "time"
 )
@@ -23,51 +25,113 @@ type Logger interface {
     Error(msg string, fields map[string]any)
 }
 
+// JSONLogger adapts a slog.Handler to Logger. Despite its name the handler
+// may write any format; New gives the original JSON-to-stderr logger.
 type JSONLogger struct {
-    level Level
+    levels    *Levels
+    component string
+    h         slog.Handler
 }
 
 func New(levelStr string) *JSONLogger {
-    switch strings.ToLower(levelStr) {
+    levels := NewLevels(ParseLevel(levelStr))
+    return NewFromHandler(NewHandler(os.Stderr, "json", levels), levels)
+}
+
+// NewFromHandler returns a Logger writing through h, dropping lines below
+// levels' base before any work is done. h should use levels as its
+// slog.Leveler so that lowering the level at runtime takes effect.
+func NewFromHandler(h slog.Handler, levels *Levels) *JSONLogger {
+    return &JSONLogger{levels: levels, h: h}
+}
+
+// Component returns a logger for one part of the service. Its lines carry
+// "component" and follow the component's level override, if any.
+func (l *JSONLogger) Component(name string) *JSONLogger {
+    return &JSONLogger{levels: l.levels, component: name, h: l.h.WithAttrs([]slog.Attr{slog.String("component", name)})}
+}
+
+func (l *JSONLogger) Levels() *Levels { return l.levels }
+
+func ParseLevel(s string) Level {
+    switch strings.ToLower(s) {
     case "debug":
-        return &JSONLogger{level: Debug}
+        return Debug
     case "warn":
-        return &JSONLogger{level: Warn}
+        return Warn
     case "error":
-        return &JSONLogger{level: Error}
+        return Error
     default:
-        return &JSONLogger{level: Info}
+        return Info
     }
 }
 
-func (l *JSONLogger) log(lv Level, msg string, fields map[string]any) {
-    if lv < l.level {
-        return
-    }
-    m := map[string]any{"ts": time.Now().UTC().Format(time.RFC3339Nano), "level": levelString(lv), "msg": msg}
-    for k, v := range fields {
-        m[k] = v
-    }
-    b, _ := json.Marshal(m)
-    os.Stderr.Write(append(b, '\n'))
-}
-
-func levelString(lv Level) string {
+// Slog maps the level onto slog's.
+func (lv Level) Slog() slog.Level {
     switch lv {
     case Debug:
-        return "debug"
-    case Info:
-        return "info"
+        return slog.LevelDebug
     case Warn:
-        return "warn"
+        return slog.LevelWarn
     case Error:
-        return "error"
+        return slog.LevelError
     default:
-        return "info"
+        return slog.LevelInfo
     }
 }
 
+// Handler exposes the underlying handler, e.g. for slog.SetDefault.
+func (l *JSONLogger) Handler() slog.Handler { return l.h }
+
+func (l *JSONLogger) log(lv Level, msg string, fields map[string]any) {
+    if lv < l.levels.Effective(l.component) {
+        return
+    }
+    ctx := context.Background()
+    if !l.h.Enabled(ctx, lv.Slog()) {
+        return
+    }
+    r := slog.NewRecord(time.Now(), lv.Slog(), msg, 0)
+    var buf [16]string
+    keys := buf[:0]
+    for k := range fields {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+        r.AddAttrs(slog.Any(k, fields[k]))
+    }
+    l.h.Handle(ctx, r)
+}
+
 func (l *JSONLogger) Debug(msg string, fields map[string]any) { l.log(Debug, msg, fields) }
 func (l *JSONLogger) Info(msg string, fields map[string]any)  { l.log(Info, msg, fields) }
 func (l *JSONLogger) Warn(msg string, fields map[string]any)  { l.log(Warn, msg, fields) }
 func (l *JSONLogger) Error(msg string, fields map[string]any) { l.log(Error, msg, fields) }
+
+// With returns a Logger that adds fields to every line; fields passed to a
+// call take precedence.
+func With(l Logger, fields map[string]any) Logger {
+    return &withLogger{l: l, fields: fields}
+}
+
+type withLogger struct {
+    l      Logger
+    fields map[string]any
+}
+
+func (w *withLogger) merge(fields map[string]any) map[string]any {
+    m := make(map[string]any, len(w.fields)+len(fields))
+    for k, v := range w.fields {
+        m[k] = v
+    }
+    for k, v := range fields {
+        m[k] = v
+    }
+    return m
+}
+
+func (w *withLogger) Debug(msg string, fields map[string]any) { w.l.Debug(msg, w.merge(fields)) }
+func (w *withLogger) Info(msg string, fields map[string]any)  { w.l.Info(msg, w.merge(fields)) }
+func (w *withLogger) Warn(msg string, fields map[string]any)  { w.l.Warn(msg, w.merge(fields)) }
+func (w *withLogger) Error(msg string, fields map[string]any) { w.l.Error(msg, w.merge(fields)) }
@@ -1,6 +1,17 @@
 package log
 
-import "testing"
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
 
 func TestJSONLoggerLevels(t *testing.T){
     l := New("debug")
@@ -10,3 +21,173 @@ func TestJSONLoggerLevels(t *testing.T){
     l.Error("e", nil)
 }
 
+type captureLogger struct{ lines []map[string]any }
+
+func (c *captureLogger) Debug(msg string, f map[string]any) { c.lines = append(c.lines, f) }
+func (c *captureLogger) Info(msg string, f map[string]any)  { c.lines = append(c.lines, f) }
+func (c *captureLogger) Warn(msg string, f map[string]any)  { c.lines = append(c.lines, f) }
+func (c *captureLogger) Error(msg string, f map[string]any) { c.lines = append(c.lines, f) }
+
+func TestWithAndContext(t *testing.T) {
+    base := &captureLogger{}
+    ctx := NewContext(context.Background(), With(base, map[string]any{"request_id": "r1", "route": "/code"}))
+    FromContext(ctx, nil).Warn("w", map[string]any{"route": "override", "x": 1})
+    if len(base.lines) != 1 || base.lines[0]["request_id"] != "r1" || base.lines[0]["route"] != "override" || base.lines[0]["x"] != 1 {
+        t.Fatalf("unexpected fields: %v", base.lines)
+    }
+    if FromContext(context.Background(), base) != Logger(base) {
+        t.Fatalf("expected fallback logger")
+    }
+}
+
+func TestHandlerFormats(t *testing.T) {
+    var b bytes.Buffer
+    l := NewFromHandler(NewHandler(&b, "json", slog.LevelInfo), NewLevels(Info))
+    l.Debug("hidden", nil)
+    l.Warn("w", map[string]any{"route": "/code", "n": 2})
+    var m map[string]any
+    if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+        t.Fatalf("not json: %q", b.String())
+    }
+    if m["level"] != "warn" || m["msg"] != "w" || m["route"] != "/code" || m["n"] != float64(2) {
+        t.Fatalf("unexpected line: %v", m)
+    }
+    if _, err := time.Parse(time.RFC3339Nano, m["ts"].(string)); err != nil {
+        t.Fatalf("bad ts: %v", m["ts"])
+    }
+
+    b.Reset()
+    NewFromHandler(NewHandler(&b, "logfmt", slog.LevelDebug), NewLevels(Debug)).Info("hello", map[string]any{"route": "/message/{code}"})
+    if !strings.Contains(b.String(), "level=info msg=hello route=/message/{code}") || !strings.HasPrefix(b.String(), "ts=") {
+        t.Fatalf("unexpected logfmt: %q", b.String())
+    }
+}
+
+func TestSampler(t *testing.T) {
+    var b bytes.Buffer
+    h := NewSampler(NewHandler(&b, "json", slog.LevelInfo), Sampling{Window: time.Hour, First: 2, Thereafter: 3})
+    now := time.Now()
+    for i := 0; i < 8; i++ {
+        h.Handle(context.Background(), slog.NewRecord(now, slog.LevelWarn, "flood", 0))
+    }
+    h.Handle(context.Background(), slog.NewRecord(now, slog.LevelWarn, "other", 0))
+    // 2 first + the 3rd and 6th after them, plus "other".
+    if n := strings.Count(b.String(), `"msg":"flood"`); n != 4 {
+        t.Fatalf("expected 4 flood lines, got %d", n)
+    }
+    b.Reset()
+    h.Handle(context.Background(), slog.NewRecord(now.Add(time.Hour), slog.LevelWarn, "flood", 0))
+    if !strings.Contains(b.String(), `"msg":"log_suppressed","suppressed_msg":"flood","dropped":4`) {
+        t.Fatalf("expected a suppression report, got %q", b.String())
+    }
+}
+
+func TestRotatingFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "app.log")
+    rf, err := OpenRotating(path, 10, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rf.Close()
+    for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+        if _, err := rf.Write([]byte(line)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for name, want := range map[string]string{path: "dddddddd\n", path + ".1": "cccccccc\n", path + ".2": "bbbbbbbb\n"} {
+        if got, _ := os.ReadFile(name); string(got) != want {
+            t.Fatalf("%s: expected %q, got %q", name, want, got)
+        }
+    }
+    if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+        t.Fatalf("expected at most 2 backups")
+    }
+}
+
+func TestSyslogHandler(t *testing.T) {
+    pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Skip(err)
+    }
+    defer pc.Close()
+    h, err := NewSyslogHandler("udp", pc.LocalAddr().String(), "msgs", "json", slog.LevelInfo)
+    if err != nil {
+        t.Skip(err)
+    }
+    NewFromHandler(h, NewLevels(Info)).Error("boom", nil)
+    pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+    buf := make([]byte, 2048)
+    n, _, err := pc.ReadFrom(buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // <27> is daemon (3) * 8 + err (3).
+    if got := string(buf[:n]); !strings.HasPrefix(got, "<27>") || !strings.Contains(got, `"msg":"boom"`) {
+        t.Fatalf("unexpected syslog packet: %q", got)
+    }
+}
+
+func TestRuntimeLevels(t *testing.T) {
+    var b bytes.Buffer
+    levels := NewLevels(Info)
+    l := NewFromHandler(NewHandler(&b, "json", levels), levels)
+    storage := l.Component("storage")
+    l.Debug("d1", nil)
+    storage.Debug("d2", nil)
+    levels.SetComponent("storage", Debug)
+    l.Debug("d3", nil)
+    storage.Debug("d4", nil)
+    if got := b.String(); strings.Contains(got, "d1") || strings.Contains(got, "d2") || strings.Contains(got, "d3") || !strings.Contains(got, `"msg":"d4","component":"storage"`) {
+        t.Fatalf("unexpected output: %s", got)
+    }
+    levels.ClearComponent("storage")
+    levels.SetBase(Error)
+    b.Reset()
+    l.Warn("w", nil)
+    storage.Warn("w", nil)
+    if b.Len() != 0 {
+        t.Fatalf("expected warn lines to be dropped at error level: %s", b.String())
+    }
+
+    levels.DebugFor(20 * time.Millisecond)
+    if levels.Base() != Debug || levels.DebugUntil().IsZero() {
+        t.Fatalf("expected a debug window")
+    }
+    levels.DebugFor(20 * time.Millisecond)
+    deadline := time.Now().Add(2 * time.Second)
+    for levels.Base() != Error {
+        if time.Now().After(deadline) {
+            t.Fatalf("level did not revert, still %s", levels.Base())
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    if !levels.DebugUntil().IsZero() {
+        t.Fatalf("window should be over")
+    }
+
+    levels.DebugFor(time.Hour)
+    levels.SetBase(Warn)
+    if levels.Base() != Warn || !levels.DebugUntil().IsZero() {
+        t.Fatalf("SetBase should end the debug window")
+    }
+}
+
+func TestDebugForOverridesComponents(t *testing.T) {
+    var b bytes.Buffer
+    levels := NewLevels(Info)
+    l := NewFromHandler(NewHandler(&b, "json", levels), levels)
+    storage := l.Component("storage")
+    levels.SetComponent("storage", Error)
+
+    levels.DebugFor(time.Hour)
+    storage.Debug("d1", nil)
+    if levels.Effective("storage") != Debug || !strings.Contains(b.String(), "d1") {
+        t.Fatalf("expected the debug window to cover overridden components: %s", b.String())
+    }
+    levels.SetBase(Info)
+    b.Reset()
+    storage.Warn("w", nil)
+    if levels.Effective("storage") != Error || b.Len() != 0 {
+        t.Fatalf("expected the override back after the window: %s", b.String())
+    }
+}
@@ -0,0 +1,100 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an append-only log file that is renamed to Path.1 (and
+// older copies shifted up to Path.MaxBackups) once a write would take it
+// past MaxBytes.
+type RotatingFile struct {
+	Path       string
+	MaxBytes   int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func OpenRotating(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{Path: path, MaxBytes: maxBytes, MaxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, st.Size()
+	return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.MaxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rf.f = nil
+	if rf.MaxBackups <= 0 {
+		os.Remove(rf.Path)
+	} else {
+		os.Remove(backup(rf.Path, rf.MaxBackups))
+		for i := rf.MaxBackups - 1; i >= 1; i-- {
+			os.Rename(backup(rf.Path, i), backup(rf.Path, i+1))
+		}
+		if err := os.Rename(rf.Path, backup(rf.Path, 1)); err != nil {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+func backup(path string, i int) string { return fmt.Sprintf("%s.%d", path, i) }
+
+// Reopen closes and reopens Path, for use after an external logrotate.
+func (rf *RotatingFile) Reopen() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f != nil {
+		rf.f.Close()
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,88 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Sampling bounds repeated lines: per Window, the first First records with
+// the same level and message pass, then only every Thereafter-th (none when
+// Thereafter is 0). The number dropped is reported once the window ends, in
+// a "log_suppressed" line per message.
+type Sampling struct {
+	Window     time.Duration
+	First      int
+	Thereafter int
+}
+
+// NewSampler wraps h with s; a zero Window or First returns h unchanged.
+func NewSampler(h slog.Handler, s Sampling) slog.Handler {
+	if s.Window <= 0 || s.First <= 0 {
+		return h
+	}
+	return &sampler{Handler: h, s: s, st: &sampleState{counts: map[sampleKey]*sampleCount{}}}
+}
+
+type sampleKey struct {
+	level slog.Level
+	msg   string
+}
+
+type sampleCount struct{ seen, dropped int }
+
+type sampleState struct {
+	mu     sync.Mutex
+	start  time.Time
+	counts map[sampleKey]*sampleCount
+}
+
+type sampler struct {
+	slog.Handler
+	s  Sampling
+	st *sampleState
+}
+
+func (h *sampler) Handle(ctx context.Context, r slog.Record) error {
+	key := sampleKey{r.Level, r.Message}
+	h.st.mu.Lock()
+	var suppressed []slog.Record
+	if r.Time.Sub(h.st.start) >= h.s.Window {
+		for k, c := range h.st.counts {
+			if c.dropped > 0 {
+				rec := slog.NewRecord(r.Time, k.level, "log_suppressed", 0)
+				rec.AddAttrs(slog.String("suppressed_msg", k.msg), slog.Int("dropped", c.dropped))
+				suppressed = append(suppressed, rec)
+			}
+		}
+		h.st.start, h.st.counts = r.Time, map[sampleKey]*sampleCount{}
+	}
+	c := h.st.counts[key]
+	if c == nil {
+		c = &sampleCount{}
+		h.st.counts[key] = c
+	}
+	c.seen++
+	n := c.seen - h.s.First
+	pass := n <= 0 || (h.s.Thereafter > 0 && n%h.s.Thereafter == 0)
+	if !pass {
+		c.dropped++
+	}
+	h.st.mu.Unlock()
+	for _, rec := range suppressed {
+		h.Handler.Handle(ctx, rec)
+	}
+	if !pass {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *sampler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sampler{Handler: h.Handler.WithAttrs(attrs), s: h.s, st: h.st}
+}
+
+func (h *sampler) WithGroup(name string) slog.Handler {
+	return &sampler{Handler: h.Handler.WithGroup(name), s: h.s, st: h.st}
+}
@@ -0,0 +1,66 @@
+//go:build !windows && !plan9
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+// NewSyslogHandler sends records to syslog (the local daemon when addr is
+// empty) with a severity matching their level. Lines are formatted as by
+// NewHandler, without the trailing newline.
+func NewSyslogHandler(network, addr, tag, format string, level slog.Leveler) (slog.Handler, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	sh := &syslogShared{w: w}
+	return &syslogHandler{inner: NewHandler(&sh.buf, format, level), sh: sh}, nil
+}
+
+type syslogShared struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	w   *syslog.Writer
+}
+
+type syslogHandler struct {
+	inner slog.Handler
+	sh    *syslogShared
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, lv slog.Level) bool {
+	return h.inner.Enabled(ctx, lv)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.sh.mu.Lock()
+	defer h.sh.mu.Unlock()
+	h.sh.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	line := strings.TrimSuffix(h.sh.buf.String(), "\n")
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.sh.w.Err(line)
+	case r.Level >= slog.LevelWarn:
+		return h.sh.w.Warning(line)
+	case r.Level >= slog.LevelInfo:
+		return h.sh.w.Info(line)
+	}
+	return h.sh.w.Debug(line)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{inner: h.inner.WithAttrs(attrs), sh: h.sh}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{inner: h.inner.WithGroup(name), sh: h.sh}
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package log
+
+import (
+	"errors"
+	"log/slog"
+)
+
+func NewSyslogHandler(network, addr, tag, format string, level slog.Leveler) (slog.Handler, error) {
+	return nil, errors.New("log: syslog is not supported on this platform")
+}
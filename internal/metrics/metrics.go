@@ -0,0 +1,307 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets suits request and storage latencies, in seconds.
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// SizeBuckets suits message sizes, in bytes.
+var SizeBuckets = []float64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+// Registry holds metrics and renders them in the Prometheus text format.
+// Instruments obtained from a nil Registry are nil and ignore updates, so
+// code can record unconditionally.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry { return &Registry{} }
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	if r == nil {
+		return nil
+	}
+	c := &CounterVec{family: newFamily(name, help, "counter", labels)}
+	r.add(c)
+	return c
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if r == nil {
+		return nil
+	}
+	h := &HistogramVec{family: newFamily(name, help, "histogram", labels), buckets: buckets}
+	r.add(h)
+	return h
+}
+
+// GaugeFunc reports fn's value at every scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	if r != nil {
+		r.add(&funcMetric{n: name, help: help, typ: "gauge", fn: fn})
+	}
+}
+
+// CounterFunc reports fn's value, which must only grow, at every scrape.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	if r != nil {
+		r.add(&funcMetric{n: name, help: help, typ: "counter", fn: fn})
+	}
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	ms := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].name() < ms[j].name() })
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range ms {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// family keeps the series of one metric, keyed by label values.
+type family struct {
+	n, help, typ string
+	labels       []string
+
+	mu     sync.RWMutex
+	series map[string]any
+	order  []string
+}
+
+func newFamily(name, help, typ string, labels []string) family {
+	return family{n: name, help: help, typ: typ, labels: labels, series: map[string]any{}}
+}
+
+func (f *family) name() string { return f.n }
+
+func (f *family) get(values []string, make func() any) any {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.n, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.series[key]; ok {
+		return s
+	}
+	s = make()
+	f.series[key] = s
+	f.order = append(f.order, key)
+	return s
+}
+
+func (f *family) each(fn func(labels string, s any)) {
+	f.mu.RLock()
+	keys := append([]string(nil), f.order...)
+	f.mu.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		f.mu.RLock()
+		s := f.series[k]
+		f.mu.RUnlock()
+		fn(f.labelPairs(strings.Split(k, "\xff")), s)
+	}
+}
+
+func (f *family) labelPairs(values []string) string {
+	if len(f.labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, l := range f.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l)
+		b.WriteString(`="`)
+		b.WriteString(escape(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func (f *family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.n, f.help, f.n, f.typ)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escape(s string) string { return escaper.Replace(s) }
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func join(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type CounterVec struct {
+	family
+}
+
+func (c *CounterVec) With(values ...string) *Counter {
+	if c == nil {
+		return nil
+	}
+	return c.get(values, func() any { return &Counter{} }).(*Counter)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.header(w)
+	c.each(func(labels string, s any) {
+		fmt.Fprintf(w, "%s%s %d\n", c.n, braces(labels), s.(*Counter).Value())
+	})
+}
+
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+func (c *Counter) Add(n uint64) {
+	if c != nil {
+		c.v.Add(n)
+	}
+}
+
+func (c *Counter) Value() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.v.Load()
+}
+
+type HistogramVec struct {
+	family
+	buckets []float64
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	if h == nil {
+		return nil
+	}
+	return h.get(values, func() any {
+		return &Histogram{upper: h.buckets, counts: make([]atomic.Uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w)
+	h.each(func(labels string, s any) {
+		hs := s.(*Histogram)
+		var cum uint64
+		for i, le := range hs.upper {
+			cum += hs.counts[i].Load()
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.n, join(labels, `le="`+formatFloat(le)+`"`), cum)
+		}
+		count := hs.count.Load()
+		fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.n, join(labels, `le="+Inf"`), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.n, braces(labels), formatFloat(math.Float64frombits(hs.sum.Load())))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.n, braces(labels), count)
+	})
+}
+
+// Histogram counts observations per bucket; counts are cumulated on output.
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Uint64 // float64 bits
+}
+
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	if i := sort.SearchFloat64s(h.upper, v); i < len(h.upper) {
+		h.counts[i].Add(1)
+	}
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+	h.count.Add(1)
+}
+
+type funcMetric struct {
+	n, help, typ string
+	fn           func() float64
+}
+
+func (f *funcMetric) name() string { return f.n }
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", f.n, f.help, f.n, f.typ, f.n, formatFloat(f.fn()))
+}
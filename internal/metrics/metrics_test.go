@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	reqs := r.Counter("http_requests_total", "Requests.", "route", "status")
+	reqs.With("/code", "201").Inc()
+	reqs.With("/code", "201").Add(2)
+	reqs.With(`/we"ird`, "500").Inc()
+	lat := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	lat.With().Observe(0.05)
+	lat.With().Observe(0.1)
+	lat.With().Observe(3)
+	r.GaugeFunc("up", "Up.", func() float64 { return 1 })
+
+	var b strings.Builder
+	r.WriteTo(&b)
+	want := `# HELP http_requests_total Requests.
+# TYPE http_requests_total counter
+http_requests_total{route="/code",status="201"} 3
+http_requests_total{route="/we\"ird",status="500"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.15
+latency_seconds_count 3
+# HELP up Up.
+# TYPE up gauge
+up 1
+`
+	if b.String() != want {
+		t.Fatalf("unexpected exposition:\n%s", b.String())
+	}
+}
+
+func TestNilRegistryIsInert(t *testing.T) {
+	var r *Registry
+	r.Counter("c", "C.", "l").With("x").Inc()
+	r.Histogram("h", "H.", DefBuckets).With().Observe(1)
+	r.GaugeFunc("g", "G.", func() float64 { return 0 })
+}
@@ -0,0 +1,380 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalid covers malformed, badly signed, expired or foreign tokens.
+	ErrInvalid = errors.New("oidc: invalid token")
+	// ErrForbidden means the token is valid but fails the claim policy.
+	ErrForbidden = errors.New("oidc: token not allowed")
+)
+
+// Claims is the part of a verified token the server cares about.
+type Claims struct {
+	Subject string
+	Issuer  string
+	Groups  []string
+	Expiry  time.Time
+}
+
+// Verifier checks RS256 and ES256 bearer tokens against the issuer's JWKS.
+// Keys are cached for CacheTTL and refetched early when a token names an
+// unknown key ID, which picks up rotations without a restart.
+type Verifier struct {
+	Issuer string
+	// Audience must appear in every token's aud claim; a Verifier without
+	// one accepts nothing, since the IdP issues tokens to other clients too.
+	Audience string
+	// JWKSURL skips discovery through the issuer's
+	// /.well-known/openid-configuration.
+	JWKSURL string
+	// GroupsClaim names the claim listing the caller's groups ("groups" by
+	// default). With RequiredGroups set, a token needs at least one of them.
+	GroupsClaim    string
+	RequiredGroups []string
+	CacheTTL       time.Duration
+	Leeway         time.Duration
+	Client         *http.Client
+	Now            func() time.Time
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	jwksURL  string
+	fetched  time.Time
+	tried    time.Time
+	fetching chan struct{} // closed when the fetch in flight ends
+}
+
+// minRefetch bounds how often unknown key IDs can trigger a JWKS fetch.
+const minRefetch = 10 * time.Second
+
+func (v *Verifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
+
+// Verify checks the token's signature, issuer, audience and validity window,
+// then the group policy. Errors other than ErrInvalid and ErrForbidden mean
+// the keys could not be fetched.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, ErrInvalid
+	}
+	if hdr.Alg != "RS256" && hdr.Alg != "ES256" {
+		return nil, ErrInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	keys, err := v.keysFor(ctx, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	verified := false
+	for _, k := range keys {
+		if verifySig(hdr.Alg, k, digest[:], sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalid
+	}
+
+	var raw map[string]json.RawMessage
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, ErrInvalid
+	}
+	var c struct {
+		Iss string   `json:"iss"`
+		Sub string   `json:"sub"`
+		Exp *float64 `json:"exp"`
+		Nbf *float64 `json:"nbf"`
+	}
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, ErrInvalid
+	}
+	now := v.now()
+	if c.Iss != v.Issuer || c.Sub == "" || c.Exp == nil {
+		return nil, ErrInvalid
+	}
+	exp := time.Unix(int64(*c.Exp), 0)
+	if !now.Before(exp.Add(v.Leeway)) {
+		return nil, ErrInvalid
+	}
+	if c.Nbf != nil && now.Add(v.Leeway).Before(time.Unix(int64(*c.Nbf), 0)) {
+		return nil, ErrInvalid
+	}
+	if v.Audience == "" || !contains(stringList(raw["aud"]), v.Audience) {
+		return nil, ErrInvalid
+	}
+
+	claim := v.GroupsClaim
+	if claim == "" {
+		claim = "groups"
+	}
+	out := &Claims{Subject: c.Sub, Issuer: c.Iss, Groups: stringList(raw[claim]), Expiry: exp}
+	if len(v.RequiredGroups) > 0 {
+		for _, g := range v.RequiredGroups {
+			if contains(out.Groups, g) {
+				return out, nil
+			}
+		}
+		return nil, ErrForbidden
+	}
+	return out, nil
+}
+
+// keysFor returns the cached keys that may have signed a token with kid,
+// refreshing the cache when it is stale or does not know kid. The JWKS is
+// fetched without holding the lock: while one caller fetches, the others
+// keep using the cached keys, and only wait when the cache cannot answer.
+func (v *Verifier) keysFor(ctx context.Context, kid string) ([]crypto.PublicKey, error) {
+	ttl := v.CacheTTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	v.mu.Lock()
+	for {
+		now := v.now()
+		_, known := v.keys[kid]
+		stale := v.keys == nil || now.Sub(v.fetched) >= ttl || (kid != "" && !known)
+		if !stale || (v.keys != nil && now.Sub(v.tried) < minRefetch) {
+			break
+		}
+		if v.fetching != nil {
+			if v.keys != nil && (kid == "" || known) {
+				break
+			}
+			wait := v.fetching
+			v.mu.Unlock()
+			select {
+			case <-wait:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			v.mu.Lock()
+			continue
+		}
+		v.tried = now
+		done := make(chan struct{})
+		v.fetching = done
+		v.mu.Unlock()
+		keys, err := v.fetch(ctx)
+		v.mu.Lock()
+		v.fetching = nil
+		close(done)
+		if err != nil && v.keys == nil {
+			v.mu.Unlock()
+			return nil, err
+		}
+		if err == nil {
+			v.keys, v.fetched = keys, now
+		}
+		break
+	}
+	defer v.mu.Unlock()
+	if kid != "" {
+		if k, ok := v.keys[kid]; ok {
+			return []crypto.PublicKey{k}, nil
+		}
+		return nil, ErrInvalid
+	}
+	out := make([]crypto.PublicKey, 0, len(v.keys))
+	for _, k := range v.keys {
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+// fetch reads the JWKS; keysFor makes sure only one runs at a time.
+func (v *Verifier) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	if v.jwksURL == "" {
+		v.jwksURL = v.JWKSURL
+	}
+	if v.jwksURL == "" {
+		var doc struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, strings.TrimSuffix(v.Issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+			return nil, err
+		}
+		if doc.Issuer != v.Issuer || doc.JWKSURI == "" {
+			return nil, errors.New("oidc: discovery document does not match issuer")
+		}
+		v.jwksURL = doc.JWKSURI
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, v.jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("oidc: no usable keys in JWKS")
+	}
+	return keys, nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, dst any) error {
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("oidc: bad RSA key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("oidc: RSA key too short")
+		}
+		return pub, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.New("oidc: unsupported curve")
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("oidc: bad EC key")
+		}
+		// ecdh validates that the point is on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, errors.New("oidc: unsupported key type")
+	}
+}
+
+func verifySig(alg string, key crypto.PublicKey, digest, sig []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(seg string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// stringList reads a claim that may be a single string or an array.
+func stringList(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return []string{one}
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		return many
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns the claims of the authenticated creator. They are
+// meant for audit records and are never written next to a message.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(ctxKey{}).(*Claims)
+	return c, ok
+}
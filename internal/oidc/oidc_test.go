@@ -0,0 +1,218 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type issuer struct {
+	srv  *httptest.Server
+	mu   sync.Mutex
+	keys []map[string]string
+	hits int
+	// gate, when set, makes each JWKS request announce itself on it and
+	// then wait for a release on it.
+	gate chan struct{}
+}
+
+func newIssuer(t *testing.T) *issuer {
+	is := &issuer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": is.srv.URL, "jwks_uri": is.srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		if is.gate != nil {
+			is.gate <- struct{}{}
+			<-is.gate
+		}
+		is.mu.Lock()
+		defer is.mu.Unlock()
+		is.hits++
+		json.NewEncoder(w).Encode(map[string]any{"keys": is.keys})
+	})
+	is.srv = httptest.NewServer(mux)
+	t.Cleanup(is.srv.Close)
+	return is
+}
+
+func (is *issuer) publish(keys ...map[string]string) {
+	is.mu.Lock()
+	is.keys = keys
+	is.mu.Unlock()
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func rsaJWK(kid string, k *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+}
+
+func ecJWK(kid string, k *ecdsa.PrivateKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	k.X.FillBytes(x)
+	k.Y.FillBytes(y)
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(x), "y": b64(y)}
+}
+
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	p, _ := json.Marshal(claims)
+	input := b64(h) + "." + b64(p)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = s
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return input + "." + b64(sig)
+}
+
+func TestVerifyAgainstLocalJWKS(t *testing.T) {
+	is := newIssuer(t)
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.publish(rsaJWK("r1", rk), ecJWK("e1", ek))
+
+	now := time.Unix(1_700_000_000, 0)
+	v := &Verifier{Issuer: is.srv.URL, Audience: "msgs", RequiredGroups: []string{"staff"}, Now: func() time.Time { return now }}
+	claims := func(over map[string]any) map[string]any {
+		c := map[string]any{"iss": is.srv.URL, "sub": "alice", "aud": []string{"msgs", "other"}, "exp": now.Add(time.Minute).Unix(), "groups": []string{"staff"}}
+		for k, val := range over {
+			c[k] = val
+		}
+		return c
+	}
+	ctx := context.Background()
+
+	for _, tok := range []string{sign(t, "RS256", "r1", rk, claims(nil)), sign(t, "ES256", "e1", ek, claims(nil))} {
+		c, err := v.Verify(ctx, tok)
+		if err != nil || c.Subject != "alice" {
+			t.Fatalf("expected valid token, got %v %v", c, err)
+		}
+	}
+	bad := map[string]string{
+		"audience": sign(t, "RS256", "r1", rk, claims(map[string]any{"aud": "someone-else"})),
+		"issuer":   sign(t, "RS256", "r1", rk, claims(map[string]any{"iss": "https://evil.example"})),
+		"expired":  sign(t, "RS256", "r1", rk, claims(map[string]any{"exp": now.Add(-time.Second).Unix()})),
+		"nbf":      sign(t, "RS256", "r1", rk, claims(map[string]any{"nbf": now.Add(time.Minute).Unix()})),
+		"key":      sign(t, "ES256", "r1", ek, claims(nil)),
+		"no aud":   sign(t, "RS256", "r1", rk, claims(map[string]any{"aud": nil})),
+	}
+	for name, tok := range bad {
+		if _, err := v.Verify(ctx, tok); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+	if _, err := v.Verify(ctx, sign(t, "RS256", "r1", rk, claims(map[string]any{"groups": "guests"}))); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestVerifyPicksUpRotatedKeys(t *testing.T) {
+	is := newIssuer(t)
+	old, _ := rsa.GenerateKey(rand.Reader, 2048)
+	is.publish(rsaJWK("k1", old))
+
+	now := time.Unix(1_700_000_000, 0)
+	v := &Verifier{Issuer: is.srv.URL, Audience: "msgs", JWKSURL: is.srv.URL + "/jwks", Now: func() time.Time { return now }}
+	claims := map[string]any{"iss": is.srv.URL, "sub": "bob", "aud": "msgs", "exp": now.Add(time.Hour).Unix()}
+	ctx := context.Background()
+	if _, err := v.Verify(ctx, sign(t, "RS256", "k1", old, claims)); err != nil {
+		t.Fatal(err)
+	}
+
+	next, _ := rsa.GenerateKey(rand.Reader, 2048)
+	is.publish(rsaJWK("k2", next))
+	tok := sign(t, "RS256", "k2", next, claims)
+	if _, err := v.Verify(ctx, tok); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("unknown kid should not refetch right away, got %v", err)
+	}
+	now = now.Add(minRefetch)
+	if _, err := v.Verify(ctx, tok); err != nil {
+		t.Fatalf("rotated key not picked up: %v", err)
+	}
+	if is.hits != 2 {
+		t.Fatalf("expected 2 JWKS fetches, got %d", is.hits)
+	}
+}
+
+func TestVerifyRequiresAudience(t *testing.T) {
+	is := newIssuer(t)
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	is.publish(rsaJWK("r1", rk))
+	now := time.Unix(1_700_000_000, 0)
+	v := &Verifier{Issuer: is.srv.URL, Now: func() time.Time { return now }}
+	tok := sign(t, "RS256", "r1", rk, map[string]any{"iss": is.srv.URL, "sub": "alice", "aud": "any-client", "exp": now.Add(time.Minute).Unix()})
+	if _, err := v.Verify(context.Background(), tok); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected ErrInvalid without a configured audience, got %v", err)
+	}
+}
+
+func TestVerifyDoesNotWaitForRefresh(t *testing.T) {
+	is := newIssuer(t)
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	is.publish(rsaJWK("r1", rk))
+	var clock atomic.Int64
+	clock.Store(1_700_000_000)
+	v := &Verifier{Issuer: is.srv.URL, Audience: "msgs", JWKSURL: is.srv.URL + "/jwks", CacheTTL: time.Hour, Now: func() time.Time { return time.Unix(clock.Load(), 0) }}
+	tok := sign(t, "RS256", "r1", rk, map[string]any{"iss": is.srv.URL, "sub": "alice", "aud": "msgs", "exp": clock.Load() + 3*3600})
+	ctx := context.Background()
+	if _, err := v.Verify(ctx, tok); err != nil {
+		t.Fatal(err)
+	}
+
+	is.gate = make(chan struct{})
+	clock.Add(2 * 3600)
+	refreshed := make(chan error)
+	go func() {
+		_, err := v.Verify(ctx, tok)
+		refreshed <- err
+	}()
+	<-is.gate // the stale cache is being refreshed
+
+	verified := make(chan error)
+	go func() {
+		_, err := v.Verify(ctx, tok)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Fatalf("expected cached key to verify, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("verification waited for the JWKS fetch")
+	}
+	is.gate <- struct{}{}
+	if err := <-refreshed; err != nil {
+		t.Fatal(err)
+	}
+}
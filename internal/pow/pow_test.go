@@ -0,0 +1,55 @@
+package pow
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func solve(challenge string, difficulty int) string {
+	for i := 0; ; i++ {
+		s := strconv.Itoa(i)
+		if LeadingZeros(challenge, s) >= difficulty {
+			return s
+		}
+	}
+}
+
+func TestIssueVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	is := &Issuer{Key: []byte("0123456789abcdef"), TTL: time.Minute, BaseBits: 8, Now: func() time.Time { return now }}
+	c, err := is.Issue(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sol := solve(c, 8)
+	id, left, err := is.Verify(c, sol)
+	if err != nil || id == "" || left != time.Minute {
+		t.Fatalf("verify failed: %q %v %v", id, left, err)
+	}
+	if LeadingZeros(c, "x") < 8 {
+		if _, _, err := is.Verify(c, "x"); err != ErrUnsolved {
+			t.Fatalf("expected ErrUnsolved, got %v", err)
+		}
+	}
+	other := &Issuer{Key: []byte("fedcba9876543210"), Now: is.Now}
+	if _, _, err := other.Verify(c, sol); err != ErrForged {
+		t.Fatalf("expected ErrForged, got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, _, err := is.Verify(c, sol); err != ErrExpired {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+}
+
+func TestDifficultyAdapts(t *testing.T) {
+	is := &Issuer{BaseBits: 16, MaxBits: 20, Threshold: 10}
+	for _, c := range []struct {
+		rate float64
+		want int
+	}{{0, 16}, {10, 16}, {20, 17}, {80, 19}, {10000, 20}} {
+		if got := is.Difficulty(c.rate); got != c.want {
+			t.Fatalf("rate %.0f: want %d, got %d", c.rate, c.want, got)
+		}
+	}
+}
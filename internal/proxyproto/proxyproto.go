@@ -0,0 +1,185 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend_msgs_golang/internal/netutil"
+)
+
+var (
+	ErrMissing   = errors.New("proxyproto: missing header")
+	ErrMalformed = errors.New("proxyproto: malformed header")
+)
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Listener accepts connections that start with a HAProxy PROXY protocol
+// header (v1 or v2). Headers are only read from peers inside Trusted; other
+// connections are passed through untouched. The header is parsed on the
+// connection's goroutine, on the first Read or RemoteAddr, so a slow peer
+// cannot stall Accept.
+type Listener struct {
+	net.Listener
+	Trusted []*net.IPNet
+	// Optional lets trusted peers omit the header, e.g. for health checks.
+	Optional bool
+	// Timeout bounds how long reading the header may take (5s by default).
+	Timeout time.Duration
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !netutil.Contains(l.Trusted, netutil.HostIP(c.RemoteAddr().String())) {
+		return c, nil
+	}
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Conn{Conn: c, r: bufio.NewReaderSize(c, 256), optional: l.Optional, timeout: timeout}, nil
+}
+
+// Conn reports the address carried in the PROXY header as RemoteAddr.
+type Conn struct {
+	net.Conn
+	r        *bufio.Reader
+	optional bool
+	timeout  time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	c.remote, c.err = parse(c.r, c.optional)
+	if c.err != nil {
+		c.Conn.Close()
+	}
+}
+
+// parse consumes a header from r. It returns a nil address for headers that
+// carry none (v1 UNKNOWN, v2 LOCAL, non-IP families, non-STREAM transports)
+// and for a missing header when optional.
+func parse(r *bufio.Reader, optional bool) (net.Addr, error) {
+	peek, err := r.Peek(5)
+	if err != nil {
+		if optional && len(peek) > 0 {
+			return nil, nil
+		}
+		return nil, ErrMissing
+	}
+	switch {
+	case string(peek) == "PROXY":
+		return parseV1(r)
+	case peek[0] == v2Signature[0]:
+		if sig, err := r.Peek(len(v2Signature)); err == nil && bytes.Equal(sig, v2Signature) {
+			return parseV2(r)
+		}
+	}
+	if optional {
+		return nil, nil
+	}
+	return nil, ErrMissing
+}
+
+// parseV1 reads "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n".
+func parseV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, ErrMalformed
+	}
+	f := strings.Split(s, " ")
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, ErrMalformed
+	}
+	ip := net.ParseIP(f[2])
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if ip == nil || err != nil || net.ParseIP(f[3]) == nil || (f[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrMalformed
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func parseV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, ErrMalformed
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, ErrMalformed
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, ErrMalformed
+	}
+	switch hdr[12] & 0x0f {
+	case 0: // LOCAL: health checks from the proxy itself
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, ErrMalformed
+	}
+	if hdr[13]&0x0f != 1 {
+		// only STREAM carries a TCP peer; anything else keeps the
+		// connection's own address
+		return nil, nil
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, ErrMalformed
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, ErrMalformed
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,106 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"backend_msgs_golang/internal/netutil"
+)
+
+func v2Header(src net.IP, port uint16) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x21, 0x11, 0, 12)
+	b = append(b, src.To4()...)
+	b = append(b, 127, 0, 0, 1)
+	b = binary.BigEndian.AppendUint16(b, port)
+	b = binary.BigEndian.AppendUint16(b, 8080)
+	return b
+}
+
+func TestParse(t *testing.T) {
+	v2Dgram := v2Header(net.ParseIP("198.51.100.4"), 40000)
+	v2Dgram[13] = 0x12
+	cases := []struct {
+		name, in string
+		optional bool
+		want     string
+		err      error
+	}{
+		{"v1 tcp4", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 8080\r\nGET", false, "203.0.113.7:51000", nil},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 51000 443\r\nGET", false, "[2001:db8::1]:51000", nil},
+		{"v1 unknown", "PROXY UNKNOWN\r\nGET", false, "", nil},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n", false, "", ErrMalformed},
+		{"v1 no crlf", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 8080\n", false, "", ErrMalformed},
+		{"v2 inet", string(v2Header(net.ParseIP("198.51.100.4"), 40000)) + "GET", false, "198.51.100.4:40000", nil},
+		{"v2 dgram", string(v2Dgram) + "GET", false, "", nil},
+		{"missing", "GET / HTTP/1.1\r\n", false, "", ErrMissing},
+		{"missing optional", "GET / HTTP/1.1\r\n", true, "", nil},
+	}
+	for _, c := range cases {
+		addr, err := parse(bufio.NewReader(strings.NewReader(c.in)), c.optional)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%s: expected error %v, got %v", c.name, c.err, err)
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != c.want {
+			t.Fatalf("%s: expected %q, got %q", c.name, c.want, got)
+		}
+	}
+}
+
+func serve(t *testing.T, trusted []*net.IPNet) string {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	})}
+	go srv.Serve(&Listener{Listener: inner, Trusted: trusted})
+	t.Cleanup(func() { srv.Close() })
+	return inner.Addr().String()
+}
+
+func send(addr, header string) (string, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	io.WriteString(c, header+"GET / HTTP/1.0\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b), nil
+}
+
+func TestListenerRemoteAddr(t *testing.T) {
+	trusted, _ := netutil.ParseCIDRs([]string{"127.0.0.1"})
+	addr := serve(t, trusted)
+	if got, err := send(addr, "PROXY TCP4 203.0.113.7 10.0.0.1 51000 8080\r\n"); err != nil || got != "203.0.113.7:51000" {
+		t.Fatalf("expected proxied address, got %q %v", got, err)
+	}
+	if _, err := send(addr, ""); err == nil {
+		t.Fatalf("expected trusted peer without header to be dropped")
+	}
+
+	addr = serve(t, nil)
+	if got, err := send(addr, ""); err != nil || !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Fatalf("expected untrusted peer to keep its address, got %q %v", got, err)
+	}
+	if got, _ := send(addr, "PROXY TCP4 203.0.113.7 10.0.0.1 51000 8080\r\n"); strings.HasPrefix(got, "203.0.113.7") {
+		t.Fatalf("header from an untrusted peer was believed")
+	}
+}
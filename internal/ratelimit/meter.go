@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Meter estimates an event rate, in events per second, as an exponentially
+// weighted moving average over Window.
+type Meter struct {
+	Window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	rate float64
+	last time.Time
+}
+
+func NewMeter(window time.Duration) *Meter {
+	if window <= 0 {
+		window = 10 * time.Second
+	}
+	return &Meter{Window: window, now: time.Now}
+}
+
+func (m *Meter) Mark() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decay()
+	m.rate += 1 / m.Window.Seconds()
+}
+
+func (m *Meter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decay()
+	return m.rate
+}
+
+func (m *Meter) decay() {
+	now := m.now()
+	if !m.last.IsZero() {
+		m.rate *= math.Exp(-now.Sub(m.last).Seconds() / m.Window.Seconds())
+	}
+	m.last = now
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rule is a budget of RPS requests per second with bursts up to Burst.
+type Rule struct {
+	RPS   int
+	Burst int
+}
+
+func (r Rule) burst() int {
+	if r.Burst <= 0 {
+		return r.RPS
+	}
+	return r.Burst
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed.
+	RetryAfter time.Duration
+}
+
+type Limiter interface {
+	Allow(ctx context.Context, key string, rule Rule) (Result, error)
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Local keeps one token bucket per key in memory. Only the most recently
+// used maxKeys buckets are kept; an evicted key starts again with a full
+// bucket.
+type Local struct {
+	maxKeys int
+	now     func() time.Time
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func NewLocal(maxKeys int) *Local {
+	if maxKeys <= 0 {
+		maxKeys = 10000
+	}
+	return &Local{
+		maxKeys: maxKeys,
+		now:     time.Now,
+		ll:      list.New(),
+		items:   map[string]*list.Element{},
+	}
+}
+
+func (l *Local) Allow(_ context.Context, key string, rule Rule) (Result, error) {
+	rate, burst := float64(rule.RPS), rule.burst()
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var b *bucket
+	if e, ok := l.items[key]; ok {
+		l.ll.MoveToFront(e)
+		b = e.Value.(*bucket)
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	} else {
+		b = &bucket{key: key, tokens: float64(burst), last: now}
+		l.items[key] = l.ll.PushFront(b)
+		if l.ll.Len() > l.maxKeys {
+			old := l.ll.Back()
+			l.ll.Remove(old)
+			delete(l.items, old.Value.(*bucket).key)
+		}
+	}
+	res := Result{Limit: burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = wait(1-b.tokens, rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = wait(float64(burst)-b.tokens, rate)
+	return res, nil
+}
+
+func wait(tokens, rate float64) time.Duration {
+	return time.Duration(tokens / rate * float64(time.Second))
+}
+
+// Len reports how many keys are tracked.
+func (l *Local) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len()
+}
+
+// Fallback asks Primary and switches to Secondary when Primary fails. After
+// a failure Primary is skipped for Cooldown so a dead backend does not add
+// latency to every request.
+type Fallback struct {
+	Primary   Limiter
+	Secondary Limiter
+	Cooldown  time.Duration
+	OnError   func(error)
+
+	skipUntil atomic.Int64
+}
+
+func (f *Fallback) Allow(ctx context.Context, key string, rule Rule) (Result, error) {
+	if time.Now().UnixNano() >= f.skipUntil.Load() {
+		res, err := f.Primary.Allow(ctx, key, rule)
+		if err == nil {
+			return res, nil
+		}
+		cooldown := f.Cooldown
+		if cooldown <= 0 {
+			cooldown = 5 * time.Second
+		}
+		f.skipUntil.Store(time.Now().Add(cooldown).UnixNano())
+		if f.OnError != nil {
+			f.OnError(err)
+		}
+	}
+	return f.Secondary.Allow(ctx, key, rule)
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLocalPerKey(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	l := NewLocal(10)
+	l.now = func() time.Time { return now }
+	rule := Rule{RPS: 1, Burst: 2}
+	for i := 0; i < 2; i++ {
+		if res, _ := l.Allow(ctx, "a", rule); !res.Allowed {
+			t.Fatalf("request %d denied", i)
+		}
+	}
+	res, _ := l.Allow(ctx, "a", rule)
+	if res.Allowed || res.RetryAfter != time.Second || res.Remaining != 0 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if res, _ := l.Allow(ctx, "b", rule); !res.Allowed {
+		t.Fatalf("other key limited")
+	}
+	now = now.Add(time.Second)
+	if res, _ := l.Allow(ctx, "a", rule); !res.Allowed {
+		t.Fatalf("bucket not refilled")
+	}
+}
+
+func TestLocalEviction(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocal(2)
+	rule := Rule{RPS: 1, Burst: 1}
+	l.Allow(ctx, "a", rule)
+	l.Allow(ctx, "b", rule)
+	l.Allow(ctx, "c", rule)
+	if l.Len() != 2 {
+		t.Fatalf("expected 2 keys, got %d", l.Len())
+	}
+	if res, _ := l.Allow(ctx, "a", rule); !res.Allowed {
+		t.Fatalf("evicted key should start full")
+	}
+}
+
+type failing struct{ calls int }
+
+func (f *failing) Allow(context.Context, string, Rule) (Result, error) {
+	f.calls++
+	return Result{}, errors.New("down")
+}
+
+func TestFallback(t *testing.T) {
+	primary := &failing{}
+	errs := 0
+	f := &Fallback{Primary: primary, Secondary: NewLocal(10), Cooldown: time.Minute, OnError: func(error) { errs++ }}
+	for i := 0; i < 3; i++ {
+		res, err := f.Allow(context.Background(), "a", Rule{RPS: 10})
+		if err != nil || !res.Allowed {
+			t.Fatalf("fallback did not answer: %+v %v", res, err)
+		}
+	}
+	if primary.calls != 1 || errs != 1 {
+		t.Fatalf("primary should be skipped during cooldown, got %d calls", primary.calls)
+	}
+}
+
+func TestMeter(t *testing.T) {
+	now := time.Unix(0, 0)
+	m := NewMeter(10 * time.Second)
+	m.now = func() time.Time { return now }
+	for i := 0; i < 600; i++ {
+		now = now.Add(100 * time.Millisecond)
+		m.Mark()
+	}
+	if r := m.Rate(); r < 9 || r > 11 {
+		t.Fatalf("expected about 10/s, got %.2f", r)
+	}
+	now = now.Add(time.Minute)
+	if r := m.Rate(); r > 0.1 {
+		t.Fatalf("expected rate to decay, got %.2f", r)
+	}
+}
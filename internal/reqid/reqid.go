@@ -0,0 +1,112 @@
+// Package reqid generates, validates and carries request IDs.
+package reqid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Format is the shape of generated IDs.
+type Format string
+
+const (
+	Hex    Format = "hex"    // 32 random hex digits
+	UUIDv7 Format = "uuidv7" // RFC 9562, time-ordered
+	ULID   Format = "ulid"   // 26 Crockford base32 characters, time-ordered
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(s))); f {
+	case "":
+		return Hex, nil
+	case Hex, UUIDv7, ULID:
+		return f, nil
+	}
+	return "", fmt.Errorf("reqid: unknown format %q", s)
+}
+
+// New returns a fresh ID; the zero Format means Hex.
+func (f Format) New() string {
+	return f.at(time.Now())
+}
+
+func (f Format) at(now time.Time) string {
+	var b [16]byte
+	rand.Read(b[:])
+	switch f {
+	case UUIDv7:
+		putMillis(b[:], now)
+		b[6] = b[6]&0x0f | 0x70
+		b[8] = b[8]&0x3f | 0x80
+		h := hex.EncodeToString(b[:])
+		return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+	case ULID:
+		putMillis(b[:], now)
+		return encodeULID(b)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// putMillis stores the Unix time in milliseconds in b's first 48 bits.
+func putMillis(b []byte, t time.Time) {
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(b[:6], ms[2:])
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func encodeULID(b [16]byte) string {
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := range out {
+		n := uint(5 * (25 - i))
+		var v uint64
+		switch {
+		case n >= 64:
+			v = hi >> (n - 64)
+		case n == 0:
+			v = lo
+		default:
+			v = lo>>n | hi<<(64-n)
+		}
+		out[i] = crockford[v&31]
+	}
+	return string(out)
+}
+
+// MaxLen bounds accepted inbound IDs.
+const MaxLen = 128
+
+// Valid reports whether an inbound ID is safe to log and echo: 1 to MaxLen
+// characters from [A-Za-z0-9._:-]. Any format is accepted, so IDs minted by
+// a gateway survive unchanged.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == ':' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request ID in ctx, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
@@ -0,0 +1,53 @@
+package reqid
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFormats(t *testing.T) {
+	now := time.UnixMilli(0x0190_1234_5678)
+	cases := []struct {
+		f    Format
+		re   string
+		head string
+	}{
+		{Hex, `^[0-9a-f]{32}$`, ""},
+		{UUIDv7, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, "01901234-5678-7"},
+		{ULID, `^[0-9A-HJKMNP-TV-Z]{26}$`, "01J0938NKR"},
+	}
+	for _, c := range cases {
+		id := c.f.at(now)
+		if !regexp.MustCompile(c.re).MatchString(id) || id[:len(c.head)] != c.head {
+			t.Fatalf("%s: unexpected id %q", c.f, id)
+		}
+		if !Valid(id) {
+			t.Fatalf("%s: generated id %q is not valid", c.f, id)
+		}
+	}
+	if a, b := ULID.at(now), ULID.at(now.Add(time.Millisecond)); a[:10] >= b[:10] {
+		t.Fatalf("ULIDs must sort by time: %s %s", a, b)
+	}
+	if f, err := ParseFormat("UUIDv7"); err != nil || f != UUIDv7 {
+		t.Fatalf("unexpected parse: %v %v", f, err)
+	}
+	if _, err := ParseFormat("v4"); err == nil {
+		t.Fatalf("expected unknown format to fail")
+	}
+}
+
+func TestValidAndContext(t *testing.T) {
+	for _, id := range []string{"", "has space", "new\nline", "<script>", string(make([]byte, MaxLen+1))} {
+		if Valid(id) {
+			t.Fatalf("accepted %q", id)
+		}
+	}
+	if !Valid("gw-2024.10:abc_DEF") {
+		t.Fatalf("rejected a well-formed id")
+	}
+	if FromContext(NewContext(context.Background(), "r1")) != "r1" || FromContext(context.Background()) != "" {
+		t.Fatalf("context round trip failed")
+	}
+}
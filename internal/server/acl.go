@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// routeAdmin is the ACL class of the /admin/ endpoints.
+const routeAdmin = "admin"
+
+// RouteClasses are the classes ACL rules are keyed by.
+var RouteClasses = []string{routeCreate, routeRead, routeAdmin}
+
+// aclClass is the ACL class of a request. Attaching a message to a reserved
+// code writes ciphertext, so it is filtered like creation; the admin
+// endpoints get their own class.
+func aclClass(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		return routeAdmin
+	}
+	if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/message/") {
+		return routeCreate
+	}
+	return routeClass(r)
+}
+
+// aclAllowed applies the CIDR allow and deny lists for the request's route
+// class to the client IP. Health checks are never filtered.
+func (s *Server) aclAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.ACL == nil || r.URL.Path == "/health" {
+		return true
+	}
+	class := aclClass(r)
+	if s.cfg.ACL.Allowed(class, net.ParseIP(s.clientIP(r))) {
+		return true
+	}
+	if s.log != nil {
+		s.logger(r.Context()).Warn("acl_denied", map[string]any{"route": class})
+	}
+	s.writeError(w, http.StatusForbidden, "forbidden")
+	return false
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"backend_msgs_golang/internal/audit"
+	"backend_msgs_golang/internal/oidc"
+	"backend_msgs_golang/internal/reqid"
+)
+
+// audit records a lifecycle event for code with who caused it: the hashed
+// client IP, the API key's tenant and the OIDC subject, when present.
+func (s *Server) audit(r *http.Request, event, code string) {
+	if s.cfg.Audit == nil {
+		return
+	}
+	e := s.auditEntry(r, event)
+	e.CodeHash = audit.Hasher{Key: s.cfg.AuditKey}.Code(code)
+	s.appendAudit(r.Context(), e)
+}
+
+// auditPrefixBurn records that n codes sharing prefix were burned after the
+// client behind r guessed too many of them; the codes themselves are unknown
+// to the server at that point.
+func (s *Server) auditPrefixBurn(r *http.Request, prefix string, n int) {
+	if s.cfg.Audit == nil {
+		return
+	}
+	e := s.auditEntry(r, audit.Burned)
+	e.PrefixHash = audit.Hasher{Key: s.cfg.AuditKey}.Prefix(prefix)
+	e.Count = n
+	s.appendAudit(r.Context(), e)
+}
+
+func (s *Server) auditEntry(r *http.Request, event string) *audit.Entry {
+	e := &audit.Entry{
+		Time:       time.Now().UTC(),
+		Event:      event,
+		ClientHash: audit.Hasher{Key: s.cfg.AuditKey}.Client(s.clientIP(r)),
+		RequestID:  reqid.FromContext(r.Context()),
+	}
+	if t, ok := s.keyedTenant(r); ok {
+		e.Tenant = t.ID
+	}
+	if c, ok := oidc.FromContext(r.Context()); ok {
+		e.Subject = c.Subject
+	}
+	return e
+}
+
+// AuditExpired records that the message or placeholder under code expired;
+// feed it from the store's expiry notifications.
+func (s *Server) AuditExpired(ctx context.Context, code string) {
+	if s.cfg.Audit == nil {
+		return
+	}
+	h := audit.Hasher{Key: s.cfg.AuditKey}
+	s.appendAudit(ctx, &audit.Entry{Time: time.Now().UTC(), Event: audit.Expired, CodeHash: h.Code(code)})
+}
+
+// appendAudit writes e even if the client has gone away, since the event
+// has already happened. Failures are logged; the request still succeeds.
+func (s *Server) appendAudit(ctx context.Context, e *audit.Entry) {
+	actx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := s.cfg.Audit.Append(actx, e); err != nil && s.log != nil {
+		s.logger(ctx).Error("audit_error", map[string]any{"event": e.Event, "error": err.Error()})
+	}
+}
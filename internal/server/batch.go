@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"backend_msgs_golang/internal/audit"
+	"backend_msgs_golang/internal/storage"
+)
+
+const maxBatchViews = 100
+
+type batchItem struct {
+	Ciphertext string `json:"ciphertext"`
+	TTL        int64  `json:"ttl"`
+	Views      int    `json:"views"`
+}
+
+type batchResult struct {
+	Code     string `json:"code,omitempty"`
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// postBatch creates one message per item and answers with results in the
+// same order; invalid items get an error code instead of failing the batch.
+func (s *Server) postBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	bs, ok := s.store.(storage.BatchStore)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	if r.Header.Get("X-Reader-CIDRs") != "" {
+		// Batches do not carry reader restrictions; refuse rather than
+		// create unrestricted messages.
+		s.writeError(w, http.StatusBadRequest, "invalid_reader_cidrs")
+		return
+	}
+	if r.Header.Get("X-Reader-Key") != "" {
+		s.writeError(w, http.StatusBadRequest, "invalid_reader_key")
+		return
+	}
+	maxBytes := s.cfg.BatchMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 8 << 20
+	}
+	maxItems := s.cfg.BatchMaxItems
+	if maxItems <= 0 {
+		maxItems = 100
+	}
+	maxItem := s.maxBody(r)
+	var req struct {
+		Messages []batchItem `json:"messages"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("invalid_batch", map[string]any{"endpoint": "batch"})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(req.Messages) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(req.Messages) > maxItems {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("batch_too_large", map[string]any{"endpoint": "batch", "items": len(req.Messages)})
+		}
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	defaultTTL, ok := s.messageTTL(w, r)
+	if !ok {
+		return
+	}
+	results := make([]batchResult, len(req.Messages))
+	pending := make([]int, 0, len(req.Messages))
+	msgs := make([]storage.NewMessage, len(req.Messages))
+	for i, it := range req.Messages {
+		if e := s.batchItemError(r, it, maxItem); e != "" {
+			results[i].Error = e
+			continue
+		}
+		ttl := time.Duration(it.TTL) * time.Second
+		if it.TTL == 0 {
+			ttl = defaultTTL
+		}
+		views := it.Views
+		if views <= 0 {
+			views = 1
+		}
+		msgs[i] = storage.NewMessage{Ciphertext: it.Ciphertext, TTL: ttl, Views: views}
+		s.metrics.messageSize.With("batch").Observe(float64(len(it.Ciphertext)))
+		pending = append(pending, i)
+	}
+
+	if len(pending) > 0 && !s.spendQuota(w, r, len(pending)) {
+		return
+	}
+	ctx := r.Context()
+	for len(pending) > 0 {
+		round := make([]storage.NewMessage, len(pending))
+		for j, i := range pending {
+			code, err := s.generateCode()
+			if err != nil {
+				s.refundQuota(r, len(pending))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			msgs[i].Code = code
+			round[j] = msgs[i]
+		}
+		created, err := bs.CreateCiphers(ctx, round)
+		if err != nil {
+			if s.log != nil {
+				s.logger(r.Context()).Error("create_ciphers_error", map[string]any{"endpoint": "batch"})
+			}
+			s.refundQuota(r, len(pending))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		retry := pending[:0]
+		for j, i := range pending {
+			if !created[j] {
+				s.metrics.collisions.With().Inc()
+				retry = append(retry, i)
+				continue
+			}
+			s.indexCode(ctx, msgs[i].Code)
+			s.audit(r, audit.Created, msgs[i].Code)
+			results[i] = batchResult{Code: msgs[i].Code, Location: "/message/" + msgs[i].Code}
+		}
+		pending = retry
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"results": results})
+}
+
+func (s *Server) batchItemError(r *http.Request, it batchItem, maxItem int64) string {
+	if int64(len(it.Ciphertext)) > maxItem {
+		return "too_large"
+	}
+	if e := cipherError(it.Ciphertext); e != "" {
+		return e
+	}
+	if it.TTL < 0 || (it.TTL > 0 && !s.ttlAllowed(r, time.Duration(it.TTL)*time.Second)) {
+		return "invalid_ttl"
+	}
+	if it.Views < 0 || it.Views > maxBatchViews {
+		return "invalid_views"
+	}
+	return ""
+}
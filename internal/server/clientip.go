@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"backend_msgs_golang/internal/netutil"
+)
+
+// clientIP returns the address of the client that sent the request. Forwarding
+// headers are only believed when the peer is one of TrustedProxies; the
+// chain is then walked from the right, skipping trusted hops.
+func (s *Server) clientIP(r *http.Request) string {
+	peer := netutil.HostIP(r.RemoteAddr)
+	if peer == nil {
+		return r.RemoteAddr
+	}
+	if !netutil.Contains(s.cfg.TrustedProxies, peer) {
+		return peer.String()
+	}
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			for _, h := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(h))
+			}
+		}
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := netutil.HostIP(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !netutil.Contains(s.cfg.TrustedProxies, ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor extracts the for= values of RFC 7239 Forwarded headers.
+func forwardedFor(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(k, "for") {
+					continue
+				}
+				val = strings.Trim(val, `"`)
+				if h, _, err := net.SplitHostPort(val); err == nil {
+					val = h
+				}
+				out = append(out, strings.Trim(val, "[]"))
+			}
+		}
+	}
+	return out
+}
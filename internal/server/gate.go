@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"backend_msgs_golang/internal/captcha"
+	"backend_msgs_golang/internal/oidc"
+	"backend_msgs_golang/internal/storage"
+	"backend_msgs_golang/internal/tlsconf"
+)
+
+func (s *Server) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// creationGate runs the checks that only apply to requests creating
+// messages. Callers with an API key, an allowed client certificate or a
+// verified bearer token skip the anti-abuse challenges. On failure the
+// response has already been written.
+func (s *Server) creationGate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	s.createMeter.Mark()
+	if !s.tenantGate(w, r) {
+		return r, false
+	}
+	if _, ok := s.keyedTenant(r); ok {
+		return r, true
+	}
+	if s.certCreator(r) {
+		return r, true
+	}
+	if s.cfg.OIDC != nil {
+		return s.authenticateCreator(w, r)
+	}
+	return r, s.checkPow(w, r) && s.checkCaptcha(w, r)
+}
+
+// certCreator reports whether the request came with a verified client
+// certificate listed in ClientCertCreators.
+func (s *Server) certCreator(r *http.Request) bool {
+	id, ok := tlsconf.PeerIdentity(r.TLS)
+	if !ok {
+		return false
+	}
+	for _, c := range s.cfg.ClientCertCreators {
+		if c == "*" || c == id {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateCreator requires a valid OIDC bearer token and puts its
+// claims in the request context for audit use.
+func (s *Server) authenticateCreator(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || tok == "" || strings.HasPrefix(tok, "sk_") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="create"`)
+		s.writeError(w, http.StatusUnauthorized, "token_required")
+		return r, false
+	}
+	c, err := s.cfg.OIDC.Verify(r.Context(), tok)
+	switch {
+	case err == nil:
+		return r.WithContext(oidc.NewContext(r.Context(), c)), true
+	case errors.Is(err, oidc.ErrForbidden):
+		if s.log != nil {
+			s.logger(r.Context()).Warn("token_forbidden", map[string]any{"endpoint": r.URL.Path})
+		}
+		s.writeError(w, http.StatusForbidden, "token_forbidden")
+	case errors.Is(err, oidc.ErrInvalid):
+		if s.log != nil {
+			s.logger(r.Context()).Warn("token_invalid", map[string]any{"endpoint": r.URL.Path})
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="create", error="invalid_token"`)
+		s.writeError(w, http.StatusUnauthorized, "token_invalid")
+	default:
+		if s.log != nil {
+			s.logger(r.Context()).Error("jwks_error", map[string]any{"endpoint": r.URL.Path, "error": err.Error()})
+		}
+		s.writeError(w, http.StatusServiceUnavailable, "auth_unavailable")
+	}
+	return r, false
+}
+
+// powChallenge hands out a proof-of-work challenge whose difficulty follows
+// the current creation rate.
+func (s *Server) powChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.cfg.Pow == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	bits := s.cfg.Pow.Difficulty(s.createMeter.Rate())
+	c, err := s.cfg.Pow.Issue(bits)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"challenge":  c,
+		"difficulty": bits,
+		"algorithm":  "sha256",
+		"expires_in": int(s.cfg.Pow.TTL.Seconds()),
+	})
+}
+
+func (s *Server) checkPow(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.Pow == nil {
+		return true
+	}
+	c, sol := r.Header.Get("X-Pow-Challenge"), r.Header.Get("X-Pow-Solution")
+	if c == "" || sol == "" {
+		s.writeError(w, http.StatusForbidden, "pow_required")
+		return false
+	}
+	id, left, err := s.cfg.Pow.Verify(c, sol)
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("pow_invalid", map[string]any{"endpoint": r.URL.Path, "error": err.Error()})
+		}
+		s.writeError(w, http.StatusForbidden, "pow_invalid")
+		return false
+	}
+	rs, ok := s.store.(storage.ReplayStore)
+	if !ok {
+		return true
+	}
+	fresh, err := rs.Consume(r.Context(), "pow:"+id, left)
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("pow_replay_error", map[string]any{"endpoint": r.URL.Path})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !fresh {
+		s.writeError(w, http.StatusForbidden, "pow_replayed")
+		return false
+	}
+	return true
+}
+
+// checkCaptcha requires a valid X-Captcha-Token, or only while the creation
+// rate is above CaptchaAboveRate when that is set.
+func (s *Server) checkCaptcha(w http.ResponseWriter, r *http.Request) bool {
+	if s.captcha == nil {
+		return true
+	}
+	if s.cfg.CaptchaAboveRate > 0 && s.createMeter.Rate() <= s.cfg.CaptchaAboveRate {
+		return true
+	}
+	token := r.Header.Get("X-Captcha-Token")
+	if token == "" {
+		s.writeError(w, http.StatusForbidden, "captcha_required")
+		return false
+	}
+	err := s.captcha.Verify(r.Context(), token, s.clientIP(r))
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, captcha.ErrFailed) {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("captcha_failed", map[string]any{"endpoint": r.URL.Path})
+		}
+		s.writeError(w, http.StatusForbidden, "captcha_failed")
+		return false
+	}
+	if s.log != nil {
+		s.logger(r.Context()).Error("captcha_error", map[string]any{"endpoint": r.URL.Path, "error": err.Error()})
+	}
+	s.writeError(w, http.StatusServiceUnavailable, "captcha_unavailable")
+	return false
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"backend_msgs_golang/internal/storage"
+)
+
+// guessStore returns the guess accounting backend, or nil when the feature
+// is off.
+func (s *Server) guessStore() storage.GuessStore {
+	if s.cfg.GuessLimit <= 0 && s.cfg.GuessBurnAfter <= 0 {
+		return nil
+	}
+	gs, _ := s.store.(storage.GuessStore)
+	return gs
+}
+
+func (s *Server) guessPolicy() storage.GuessPolicy {
+	return storage.GuessPolicy{
+		Limit:      s.cfg.GuessLimit,
+		Window:     s.cfg.GuessWindow,
+		Lockout:    s.cfg.GuessLockout,
+		MaxLockout: s.cfg.GuessMaxLockout,
+		BurnAfter:  s.cfg.GuessBurnAfter,
+	}
+}
+
+// codePrefix groups a code with its neighbours: by default the codes that
+// differ only in the last character, or the first GuessPrefixLen characters.
+func (s *Server) codePrefix(code string) string {
+	n := s.cfg.GuessPrefixLen
+	if n <= 0 {
+		n = len(code) - 1
+	}
+	if n > len(code) {
+		n = len(code)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return code[:n]
+}
+
+// indexCode makes a freshly created code reachable for neighbour burning.
+func (s *Server) indexCode(ctx context.Context, code string) {
+	gs := s.guessStore()
+	if gs == nil || s.cfg.GuessBurnAfter <= 0 {
+		return
+	}
+	if err := gs.IndexCode(ctx, code, s.codePrefix(code), s.cfg.PlaceholderTTL+s.cfg.MessageTTL); err != nil && s.log != nil {
+		s.logger(ctx).Error("index_code_error", map[string]any{"endpoint": "code"})
+	}
+}
+
+// lockedOut answers 429 when the client is serving a lockout for too many
+// failed lookups.
+func (s *Server) lockedOut(w http.ResponseWriter, r *http.Request) bool {
+	gs := s.guessStore()
+	if gs == nil {
+		return false
+	}
+	d, err := gs.Lockout(r.Context(), s.clientIP(r))
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("lockout_error", map[string]any{"endpoint": "message_get"})
+		}
+		return false
+	}
+	if d <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(d)))
+	w.WriteHeader(http.StatusTooManyRequests)
+	return true
+}
+
+// recordMiss accounts a lookup of a code that does not exist.
+func (s *Server) recordMiss(r *http.Request, code string) {
+	gs := s.guessStore()
+	if gs == nil {
+		return
+	}
+	prefix := s.codePrefix(code)
+	lock, burned, err := gs.RecordMiss(r.Context(), s.clientIP(r), prefix, s.guessPolicy())
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("record_miss_error", map[string]any{"endpoint": "message_get"})
+		}
+		return
+	}
+	if burned > 0 {
+		s.auditPrefixBurn(r, prefix, burned)
+	}
+	if s.log == nil {
+		return
+	}
+	if lock > 0 {
+		s.logger(r.Context()).Warn("guess_lockout", map[string]any{"endpoint": "message_get", "lockout_ms": lock.Milliseconds()})
+	}
+	if burned > 0 {
+		s.logger(r.Context()).Warn("guess_burn", map[string]any{"endpoint": "message_get", "burned": burned})
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"time"
+
+	applog "backend_msgs_golang/internal/log"
+	"backend_msgs_golang/internal/reqid"
+	"backend_msgs_golang/internal/trace"
+)
+
+// withLogger stores a logger in r's context that tags every line with the
+// request ID, method, route template and client IP, plus the trace IDs when
+// the request is traced.
+func (s *Server) withLogger(r *http.Request, rid string) *http.Request {
+	if s.log == nil {
+		return r
+	}
+	fields := map[string]any{
+		"request_id": rid,
+		"method":     r.Method,
+		"route":      routeLabel(r.URL.Path),
+		"client_ip":  s.clientIP(r),
+	}
+	if span := trace.SpanFromContext(r.Context()); span != nil {
+		sc := span.Context()
+		fields["trace_id"], fields["span_id"] = sc.TraceID.String(), sc.SpanID.String()
+	}
+	return r.WithContext(applog.NewContext(r.Context(), applog.With(s.log, fields)))
+}
+
+// logger returns the request's logger, or the server's outside a request.
+func (s *Server) logger(ctx context.Context) applog.Logger {
+	return applog.FromContext(ctx, s.log)
+}
+
+// componentLogger tags a component's logger with the current request ID;
+// it returns nil when the component has no logger.
+func (s *Server) componentLogger(ctx context.Context, l applog.Logger) applog.Logger {
+	if l == nil {
+		return nil
+	}
+	if id := reqid.FromContext(ctx); id != "" {
+		return applog.With(l, map[string]any{"request_id": id})
+	}
+	return l
+}
+
+// accessLog writes one line per request. Failed requests (4xx, 5xx) are
+// always logged; AccessLogSuccessRate sets the share of the others kept.
+func (s *Server) accessLog(r *http.Request, sw *statusWriter, d time.Duration) {
+	if !s.cfg.AccessLog || s.log == nil {
+		return
+	}
+	status := sw.Status()
+	if rate := s.cfg.AccessLogSuccessRate; status < 400 && rate < 1 && (rate <= 0 || rand.Float64() >= rate) {
+		return
+	}
+	s.logger(r.Context()).Info("access", map[string]any{
+		"status":      status,
+		"duration_ms": float64(d.Microseconds()) / 1000,
+		"bytes":       sw.bytes,
+	})
+}
+
+type logLevelState struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+	DebugUntil *time.Time        `json:"debug_until,omitempty"`
+}
+
+// logLevel reports the log levels and, on PUT, changes them. A body with a
+// component sets that component's override, or clears it when level is
+// empty; without one it sets the base level.
+func (s *Server) logLevel(w http.ResponseWriter, r *http.Request) {
+	levels := s.cfg.LogLevels
+	if levels == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !s.adminAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Component string `json:"component"`
+			Level     string `json:"level"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid_log_level")
+			return
+		}
+		if req.Component != "" && req.Level == "" {
+			levels.ClearComponent(req.Component)
+		} else if lv, err := applog.ParseLevelStrict(req.Level); err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid_log_level")
+			return
+		} else if req.Component != "" {
+			if !knownComponent(req.Component) {
+				s.writeError(w, http.StatusBadRequest, "unknown_component")
+				return
+			}
+			levels.SetComponent(req.Component, lv)
+		} else {
+			levels.SetBase(lv)
+		}
+		if s.log != nil {
+			s.logger(r.Context()).Info("log_level_changed", map[string]any{"component": req.Component, "level": req.Level})
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	st := logLevelState{Level: levels.Base().String(), Components: map[string]string{}}
+	for c, lv := range levels.Overrides() {
+		st.Components[c] = lv.String()
+	}
+	if until := levels.DebugUntil(); !until.IsZero() {
+		st.DebugUntil = &until
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// LogComponents are the components whose level can be overridden.
+var LogComponents = []string{"server", "storage", "ratelimit"}
+
+func knownComponent(c string) bool {
+	for _, k := range LogComponents {
+		if k == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend_msgs_golang/internal/metrics"
+	"backend_msgs_golang/internal/storage"
+	"backend_msgs_golang/internal/trace"
+)
+
+// serverMetrics are the instruments the server records into. They are all
+// nil, and ignore updates, when Config.Metrics is not set.
+type serverMetrics struct {
+	requests    *metrics.CounterVec
+	latency     *metrics.HistogramVec
+	storageTime *metrics.HistogramVec
+	storageErrs *metrics.CounterVec
+	collisions  *metrics.CounterVec
+	rateLimited *metrics.CounterVec
+	messageSize *metrics.HistogramVec
+}
+
+func newServerMetrics(reg *metrics.Registry) serverMetrics {
+	return serverMetrics{
+		requests:    reg.Counter("http_requests_total", "HTTP requests by route, method and status.", "route", "method", "status"),
+		latency:     reg.Histogram("http_request_duration_seconds", "HTTP request latency by route and status.", metrics.DefBuckets, "route", "status"),
+		storageTime: reg.Histogram("storage_operation_duration_seconds", "Storage operation latency by method.", metrics.DefBuckets, "method"),
+		storageErrs: reg.Counter("storage_operation_errors_total", "Failed storage operations by method.", "method"),
+		collisions:  reg.Counter("code_collisions_total", "Generated codes that were already taken."),
+		rateLimited: reg.Counter("rate_limit_rejections_total", "Requests refused by the rate limiter by route class.", "route"),
+		messageSize: reg.Histogram("message_size_bytes", "Accepted ciphertext sizes by endpoint.", metrics.SizeBuckets, "endpoint"),
+	}
+}
+
+func (s *Server) registerMetrics(reg *metrics.Registry) {
+	s.metrics = newServerMetrics(reg)
+	if s.captcha != nil {
+		c := s.captcha
+		reg.CounterFunc("captcha_passed_total", "CAPTCHA tokens accepted.", func() float64 { return float64(c.Passed.Load()) })
+		reg.CounterFunc("captcha_failed_total", "CAPTCHA tokens rejected by the provider.", func() float64 { return float64(c.Failed.Load()) })
+		reg.CounterFunc("captcha_errors_total", "CAPTCHA verifications that could not reach the provider.", func() float64 { return float64(c.Errors.Load()) })
+	}
+}
+
+// observeRequest records a finished request under its route template, so
+// codes never become label values.
+func (s *Server) observeRequest(r *http.Request, status int, d time.Duration) {
+	route, code := routeLabel(r.URL.Path), strconv.Itoa(status)
+	s.metrics.requests.With(route, methodLabel(r.Method), code).Inc()
+	s.metrics.latency.With(route, code).Observe(d.Seconds())
+}
+
+// methodLabel folds the methods no route serves into "other"; net/http
+// accepts any token as a method.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodPost, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+func routeLabel(path string) string {
+	switch path {
+	case "/code", "/message", "/batch/messages", "/challenge/pow", "/admin/keys", "/admin/log-level", "/health":
+		return path
+	}
+	if rest, ok := strings.CutPrefix(path, "/message/"); ok {
+		switch _, action, _ := strings.Cut(rest, "/"); action {
+		case "":
+			return "/message/{code}"
+		case "reveal", "claim":
+			return "/message/{code}/" + action
+		}
+	}
+	return "other"
+}
+
+// StorageObserver records storage latency and errors, a child span per call
+// when tracing, and a line per call to StorageLog; pass it to the store's
+// SetObserver.
+func (s *Server) StorageObserver() storage.Observer { return storageObserver{s} }
+
+type storageObserver struct{ s *Server }
+
+func (o storageObserver) Observe(ctx context.Context, op string) func(error) {
+	start := time.Now()
+	_, span := o.s.cfg.Tracer.Start(ctx, "storage."+op, trace.KindClient, trace.SpanContext{})
+	span.SetAttr("db.system", "redis")
+	span.SetAttr("db.operation", op)
+	return func(err error) {
+		d := time.Since(start)
+		o.s.metrics.storageTime.With(op).Observe(d.Seconds())
+		if err != nil {
+			o.s.metrics.storageErrs.With(op).Inc()
+		}
+		span.Finish(err)
+		if lg := o.s.componentLogger(ctx, o.s.cfg.StorageLog); lg != nil {
+			fields := map[string]any{"op": op, "duration_ms": float64(d.Microseconds()) / 1000}
+			if err != nil {
+				fields["error"] = err.Error()
+				lg.Warn("storage_error", fields)
+			} else {
+				lg.Debug("storage_op", fields)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+
+	"backend_msgs_golang/internal/audit"
+	"backend_msgs_golang/internal/netutil"
+	"backend_msgs_golang/internal/storage"
+)
+
+// maxReaderNetworks bounds the X-Reader-CIDRs list kept per message.
+const maxReaderNetworks = 32
+
+// readerPolicy parses X-Reader-CIDRs and returns it with the store that
+// enforces it. Both are nil when the header is absent; on failure the
+// response has already been written.
+func (s *Server) readerPolicy(w http.ResponseWriter, r *http.Request, endpoint string) (storage.RestrictedStore, *storage.ReaderPolicy, bool) {
+	v := r.Header.Get("X-Reader-CIDRs")
+	if v == "" {
+		return nil, nil, true
+	}
+	rs, ok := s.store.(storage.RestrictedStore)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return nil, nil, false
+	}
+	nets, err := netutil.ParseCIDRs(strings.Split(v, ","))
+	if err != nil || len(nets) == 0 || len(nets) > maxReaderNetworks || r.Header.Get("X-Reader-Key") != "" {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("invalid_reader_cidrs", map[string]any{"endpoint": endpoint})
+		}
+		s.writeError(w, http.StatusBadRequest, "invalid_reader_cidrs")
+		return nil, nil, false
+	}
+	return rs, &storage.ReaderPolicy{Networks: nets, BurnAfter: s.cfg.ReaderBurnAfter}, true
+}
+
+// readerKey checks X-Reader-Key, the base64 Ed25519 public key a message is
+// bound to, and returns it with the store that binds it. It returns a nil
+// store and "" when the header is absent; on failure the response has
+// already been written.
+func (s *Server) readerKey(w http.ResponseWriter, r *http.Request, endpoint string) (storage.BoundStore, string, bool) {
+	rk := r.Header.Get("X-Reader-Key")
+	if rk == "" {
+		return nil, "", true
+	}
+	bs, ok := s.store.(storage.BoundStore)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return nil, "", false
+	}
+	pub, err := base64.StdEncoding.DecodeString(rk)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("invalid_reader_key", map[string]any{"endpoint": endpoint})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, "", false
+	}
+	return bs, rk, true
+}
+
+// writeRestricted releases the message only to clients inside its reader
+// networks. Refused reads leave it in place until BurnAfter is reached.
+func (s *Server) writeRestricted(w http.ResponseWriter, r *http.Request, rs storage.RestrictedStore, code string) {
+	ct, res, err := rs.ReadFrom(r.Context(), code, net.ParseIP(s.clientIP(r)))
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("get_delete_error", map[string]any{"endpoint": "message_get"})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch res {
+	case storage.ReadOK:
+		s.audit(r, audit.Read, code)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(ct))
+	case storage.ReadRefused, storage.ReadBurned:
+		if s.log != nil {
+			s.logger(r.Context()).Warn("reader_refused", map[string]any{"endpoint": "message_get", "burned": res == storage.ReadBurned})
+		}
+		if res == storage.ReadBurned {
+			s.audit(r, audit.Burned, code)
+		}
+		s.writeError(w, http.StatusForbidden, "reader_not_allowed")
+	default:
+		s.recordMiss(r, code)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"backend_msgs_golang/internal/events"
+	"backend_msgs_golang/internal/netutil"
+	"backend_msgs_golang/internal/reqid"
+	"backend_msgs_golang/internal/trace"
+)
+
+func (s *Server) requestIDHeader() string {
+	if s.cfg.RequestIDHeader != "" {
+		return s.cfg.RequestIDHeader
+	}
+	return "X-Request-Id"
+}
+
+// requestID keeps a well-formed inbound ID sent by a peer in RequestIDFrom,
+// so gateway and service logs correlate. Otherwise a traced request uses
+// its trace ID and the rest get a fresh ID in RequestIDFormat.
+func (s *Server) requestID(r *http.Request, span *trace.Span) string {
+	if id := r.Header.Get(s.requestIDHeader()); id != "" && reqid.Valid(id) &&
+		netutil.Contains(s.cfg.RequestIDFrom, netutil.HostIP(r.RemoteAddr)) {
+		return id
+	}
+	if span != nil {
+		return span.Context().TraceID.String()
+	}
+	return s.cfg.RequestIDFormat.New()
+}
+
+// emit sends an event tagged with the ID of the request it came from.
+func (s *Server) emit(ctx context.Context, typ string, fields map[string]any) {
+	if s.cfg.Events == nil {
+		return
+	}
+	if id := reqid.FromContext(ctx); id != "" {
+		fields["request_id"] = id
+	}
+	s.cfg.Events.Emit(ctx, events.New(typ, fields))
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"backend_msgs_golang/internal/storage"
+)
+
+// DefaultPreviewBotAgents lists user-agent fragments of link unfurlers and
+// mail scanners that fetch links without a human behind them.
+var DefaultPreviewBotAgents = []string{
+	"Slackbot",
+	"Slack-ImgProxy",
+	"facebookexternalhit",
+	"Facebot",
+	"Twitterbot",
+	"LinkedInBot",
+	"Discordbot",
+	"TelegramBot",
+	"WhatsApp",
+	"SkypeUriPreview",
+	"MicrosoftPreview",
+	"Microsoft Office",
+	"Teams",
+	"BingPreview",
+	"Google-Safety",
+	"Googlebot",
+	"Applebot",
+	"redditbot",
+	"Embedly",
+	"Iframely",
+	"vkShare",
+	"Pinterest",
+}
+
+func (s *Server) isPreviewBot(r *http.Request) bool {
+	if len(s.cfg.PreviewBotAgents) == 0 {
+		return false
+	}
+	ua := strings.ToLower(r.UserAgent())
+	if ua == "" {
+		return false
+	}
+	for _, a := range s.cfg.PreviewBotAgents {
+		if a != "" && strings.Contains(ua, strings.ToLower(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// offerReveal answers a GET in reveal mode without touching the message; the
+// ciphertext is only released by POST /message/{code}/reveal.
+func (s *Server) offerReveal(w http.ResponseWriter, r *http.Request, code string) {
+	ctx := r.Context()
+	if p, ok := s.store.(storage.Peeker); ok {
+		exists, err := p.Exists(ctx, code)
+		if err != nil {
+			if s.log != nil {
+				s.logger(r.Context()).Error("exists_error", map[string]any{"endpoint": "message_get"})
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			s.recordMiss(r, code)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+	resp := map[string]any{"reveal": "/message/" + code + "/reveal"}
+	if s.cfg.RevealNonce {
+		cs, ok := s.store.(storage.ChallengeStore)
+		if !ok {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		var b [32]byte
+		rand.Read(b[:])
+		nonce := base64.RawURLEncoding.EncodeToString(b[:])
+		if err := cs.PutChallenge(ctx, code, "reveal:"+nonce, s.challengeTTL()); err != nil {
+			if s.log != nil {
+				s.logger(r.Context()).Error("put_challenge_error", map[string]any{"endpoint": "message_get"})
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp["nonce"] = nonce
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) revealMessage(w http.ResponseWriter, r *http.Request, code string) {
+	ctx := r.Context()
+	if bs, ok := s.store.(storage.BoundStore); ok {
+		_, bound, err := bs.ReaderKey(ctx, code)
+		if err != nil {
+			if s.log != nil {
+				s.logger(r.Context()).Error("reader_key_error", map[string]any{"endpoint": "message_reveal"})
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if bound {
+			s.issueChallenge(w, r, bs, code)
+			return
+		}
+	}
+	if s.cfg.RevealNonce {
+		cs, ok := s.store.(storage.ChallengeStore)
+		if !ok {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		var req struct {
+			Nonce string `json:"nonce"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Nonce == "" {
+			if s.log != nil {
+				s.logger(r.Context()).Warn("invalid_reveal", map[string]any{"endpoint": "message_reveal"})
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		taken, err := cs.TakeChallenge(ctx, code, "reveal:"+req.Nonce)
+		if err != nil {
+			if s.log != nil {
+				s.logger(r.Context()).Error("take_challenge_error", map[string]any{"endpoint": "message_reveal"})
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !taken {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	s.writeMessage(w, r, code)
+}
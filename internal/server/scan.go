@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend_msgs_golang/internal/storage"
+)
+
+// maxCachedBans bounds the ban cache; storage stays the source of truth, so
+// a ban dropped from the cache only costs a lookup.
+const maxCachedBans = 10000
+
+// banCache remembers bans seen from storage so that a banned client's
+// requests stop costing a Redis round trip until the ban ends.
+type banCache struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func (c *banCache) get(client string, now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.until[client]
+	if !ok {
+		return 0
+	}
+	if !now.Before(t) {
+		delete(c.until, client)
+		return 0
+	}
+	return t.Sub(now)
+}
+
+// put caches a ban. When the cache is full, expired bans are dropped, then
+// arbitrary ones until a tenth of the room is free.
+func (c *banCache) put(client string, until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.until == nil {
+		c.until = map[string]time.Time{}
+	}
+	if _, ok := c.until[client]; !ok && len(c.until) >= maxCachedBans {
+		c.evict(time.Now())
+	}
+	c.until[client] = until
+}
+
+func (c *banCache) evict(now time.Time) {
+	for k, t := range c.until {
+		if !now.Before(t) {
+			delete(c.until, k)
+		}
+	}
+	for k := range c.until {
+		if len(c.until) < maxCachedBans-maxCachedBans/10 {
+			break
+		}
+		delete(c.until, k)
+	}
+}
+
+// banStore returns the ban backend, or nil when scan detection is off.
+func (s *Server) banStore() storage.BanStore {
+	if s.cfg.Scan.NotFoundLimit <= 0 && s.cfg.Scan.ConflictLimit <= 0 {
+		return nil
+	}
+	bs, _ := s.store.(storage.BanStore)
+	return bs
+}
+
+// banned answers 403 with Retry-After while the client is banned. Health
+// checks are never refused.
+func (s *Server) banned(w http.ResponseWriter, r *http.Request) bool {
+	bs := s.banStore()
+	if bs == nil || r.URL.Path == "/health" {
+		return false
+	}
+	client := s.clientIP(r)
+	now := time.Now()
+	d := s.bans.get(client, now)
+	if d <= 0 {
+		var err error
+		d, err = bs.Banned(r.Context(), client)
+		if err != nil {
+			if s.log != nil {
+				s.logger(r.Context()).Error("ban_lookup_error", map[string]any{"route": routeClass(r)})
+			}
+			return false
+		}
+		if d <= 0 {
+			return false
+		}
+		s.bans.put(client, now.Add(d))
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(d)))
+	s.writeError(w, http.StatusForbidden, "banned")
+	return true
+}
+
+// watchScan counts the responses that suggest a sweep of the code space:
+// 404 on reads and 409 on attaches under /message/.
+func (s *Server) watchScan(r *http.Request, status int) {
+	bs := s.banStore()
+	if bs == nil || !strings.HasPrefix(r.URL.Path, "/message/") {
+		return
+	}
+	var kind string
+	switch {
+	case status == http.StatusNotFound && r.Method != http.MethodPut:
+		kind = storage.ScanNotFound
+	case status == http.StatusConflict && r.Method == http.MethodPut:
+		kind = storage.ScanConflict
+	default:
+		return
+	}
+	client := s.clientIP(r)
+	ban, offence, err := bs.RecordScan(r.Context(), client, kind, s.cfg.Scan)
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("record_scan_error", map[string]any{"route": routeClass(r)})
+		}
+		return
+	}
+	if ban <= 0 {
+		return
+	}
+	s.bans.put(client, time.Now().Add(ban))
+	reason := "not_found"
+	if kind == storage.ScanConflict {
+		reason = "conflict"
+	}
+	s.emit(r.Context(), "client_banned", map[string]any{
+		"client_ip":   client,
+		"reason":      reason,
+		"offence":     offence,
+		"ban_seconds": ceilSeconds(ban),
+	})
+}
@@ -2,17 +2,33 @@ package server
 
 import (
     "context"
+    "crypto/ed25519"
     "crypto/rand"
+    "crypto/tls"
     "encoding/json"
     "encoding/base64"
     "io"
-    "math/big"
+    "net"
     "net/http"
+    "strconv"
     "strings"
     "time"
 
+	"backend_msgs_golang/internal/acl"
+	"backend_msgs_golang/internal/audit"
+	"backend_msgs_golang/internal/captcha"
+	"backend_msgs_golang/internal/codegen"
+	"backend_msgs_golang/internal/events"
 	applog "backend_msgs_golang/internal/log"
+	"backend_msgs_golang/internal/metrics"
+	"backend_msgs_golang/internal/oidc"
+	"backend_msgs_golang/internal/pow"
+	"backend_msgs_golang/internal/proxyproto"
+	"backend_msgs_golang/internal/ratelimit"
+	"backend_msgs_golang/internal/reqid"
 	"backend_msgs_golang/internal/storage"
+	"backend_msgs_golang/internal/tenant"
+	"backend_msgs_golang/internal/trace"
 )
 
 type Config struct {
@@ -27,6 +43,69 @@ type Config struct {
     AllowedOrigins    []string
     RateLimitRPS      int
     RateBurst         int
+    RateLimitMaxKeys  int
+    CreateRateRPS     int
+    CreateRateBurst   int
+    Limiter           ratelimit.Limiter
+    TrustedProxies    []*net.IPNet
+    ChallengeTTL      time.Duration
+    RevealMode        bool
+    RevealNonce       bool
+    PreviewBotAgents  []string
+    BatchMaxItems     int
+    BatchMaxBytes     int64
+    Codes             codegen.Generator
+    GuessLimit        int
+    GuessWindow       time.Duration
+    GuessLockout      time.Duration
+    GuessMaxLockout   time.Duration
+    GuessBurnAfter    int
+    GuessPrefixLen    int
+    Pow               *pow.Issuer
+    Captcha           captcha.Verifier
+    CaptchaAboveRate  float64
+    Tenants           tenant.Store
+    AnonymousTenant   *tenant.Tenant
+    AdminToken        string
+    OIDC              *oidc.Verifier
+    TLS               *tls.Config
+    // ClientCertCreators lists the client certificate identities allowed to
+    // create without other credentials; "*" admits any verified certificate.
+    ClientCertCreators []string
+    ACL                *acl.List
+    // ReaderBurnAfter is how many reads from outside a message's reader
+    // networks burn it; 0 only refuses them.
+    ReaderBurnAfter int
+    // ProxyProtocolFrom lists the load balancers whose PROXY protocol
+    // headers are read; the header's source address becomes RemoteAddr.
+    ProxyProtocolFrom     []*net.IPNet
+    ProxyProtocolOptional bool
+    Scan                  storage.ScanPolicy
+    Events                events.Sink
+    Metrics               *metrics.Registry
+    // Tracer, when set, records a span per request and per storage call;
+    // the trace ID then doubles as X-Request-Id.
+    Tracer *trace.Tracer
+    // AccessLog writes one line per request; AccessLogSuccessRate is the
+    // share of non-error requests kept (1 keeps all of them).
+    AccessLog            bool
+    AccessLogSuccessRate float64
+    // RequestIDHeader names the request ID header (X-Request-Id by default).
+    // Inbound IDs are kept only from peers in RequestIDFrom; generated ones
+    // use RequestIDFormat.
+    RequestIDHeader string
+    RequestIDFrom   []*net.IPNet
+    RequestIDFormat reqid.Format
+    // StorageLog and RateLimitLog, when set, receive the storage and rate
+    // limiter lines so their levels can be tuned apart from the server's.
+    StorageLog   applog.Logger
+    RateLimitLog applog.Logger
+    // LogLevels, when set, can be read and changed at /admin/log-level.
+    LogLevels *applog.Levels
+    // Audit, when set, receives a hash-chained entry per message lifecycle
+    // event; AuditKey keys the hashes of codes and client IPs.
+    Audit    audit.Store
+    AuditKey []byte
 }
 
 type Server struct {
@@ -34,33 +113,65 @@ type Server struct {
     store  storage.Storage
     router http.Handler
     log    applog.Logger
-    tokens chan struct{}
+    limiter ratelimit.Limiter
+    codes  codegen.Generator
+    createMeter *ratelimit.Meter
+    captcha     *captcha.Counted
+    bans        banCache
+    metrics     serverMetrics
 }
 
 func New(cfg Config, st storage.Storage, lg applog.Logger) *Server {
-    s := &Server{cfg: cfg, store: st, log: lg}
-    if cfg.RateLimitRPS > 0 {
-        burst := cfg.RateBurst
-        if burst <= 0 { burst = cfg.RateLimitRPS }
-        s.tokens = make(chan struct{}, burst)
-        t := time.NewTicker(time.Second / time.Duration(cfg.RateLimitRPS))
-        go func() {
-            for range t.C {
-                select { case s.tokens <- struct{}{}: default: }
-            }
-        }()
+    s := &Server{cfg: cfg, store: st, log: lg, codes: cfg.Codes, createMeter: ratelimit.NewMeter(10 * time.Second)}
+    if cfg.Captcha != nil {
+        s.captcha = &captcha.Counted{Verifier: cfg.Captcha}
+    }
+    if s.codes == nil {
+        s.codes = codegen.Alphabet{Length: 8, Chars: codegen.DefaultAlphabet}
+    }
+    s.registerMetrics(cfg.Metrics)
+    s.limiter = cfg.Limiter
+    if s.limiter == nil && (cfg.RateLimitRPS > 0 || cfg.CreateRateRPS > 0) {
+        s.limiter = ratelimit.NewLocal(cfg.RateLimitMaxKeys)
     }
     mux := http.NewServeMux()
     mux.HandleFunc("/code", s.postCode)
+    mux.HandleFunc("/message", s.createMessage)
     mux.HandleFunc("/message/", s.message)
+    mux.HandleFunc("/batch/messages", s.postBatch)
+    mux.HandleFunc("/challenge/pow", s.powChallenge)
+    mux.HandleFunc("/admin/keys", s.provisionKey)
+    mux.HandleFunc("/admin/log-level", s.logLevel)
     mux.HandleFunc("/health", s.health)
     s.router = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        sw := &statusWriter{ResponseWriter: w}
+        w = sw
+        r, span := s.startSpan(r)
+        rid := s.requestID(r, span)
+        r = s.withLogger(r.WithContext(reqid.NewContext(r.Context(), rid)), rid)
+        defer func() {
+            s.observeRequest(r, sw.Status(), time.Since(start))
+            s.watchScan(r, sw.Status())
+            finishSpan(span, sw.Status())
+            s.accessLog(r, sw, time.Since(start))
+        }()
         s.secHeaders(w)
         s.corsHeaders(w, r)
         if r.Method == http.MethodOptions { w.WriteHeader(http.StatusNoContent); return }
-        rid := s.requestID()
-        w.Header().Set("X-Request-Id", rid)
-        if !s.allow() { w.WriteHeader(http.StatusTooManyRequests); return }
+        w.Header().Set(s.requestIDHeader(), rid)
+        if !s.aclAllowed(w, r) { return }
+        if s.banned(w, r) { return }
+        r, ok := s.authenticate(w, r)
+        if !ok { return }
+        if !s.allow(w, r) {
+            s.metrics.rateLimited.With(routeClass(r)).Inc()
+            w.WriteHeader(http.StatusTooManyRequests)
+            return
+        }
+        if routeClass(r) == routeCreate {
+            if r, ok = s.creationGate(w, r); !ok { return }
+        }
         mux.ServeHTTP(w, r)
     })
     return s
@@ -84,34 +195,79 @@ func (s *Server) corsHeaders(w http.ResponseWriter, r *http.Request) {
             w.Header().Set("Access-Control-Allow-Origin", o)
             w.Header().Set("Vary", "Origin")
             w.Header().Set("Access-Control-Allow-Methods", "GET,PUT,POST,OPTIONS")
-            w.Header().Set("Access-Control-Allow-Headers", "Content-Type,X-Request-Id")
+            w.Header().Set("Access-Control-Allow-Headers", "Content-Type,"+s.requestIDHeader()+",X-Reader-Key,X-Pow-Challenge,X-Pow-Solution,X-Captcha-Token,X-Api-Key,X-Message-TTL,X-Reader-CIDRs,Authorization,traceparent")
             break
         }
     }
 }
 
-func (s *Server) requestID() string {
-    var b [16]byte
-    rand.Read(b[:])
-    const hex = "0123456789abcdef"
-    out := make([]byte, 32)
-    for i := 0; i < 16; i++ { out[i*2] = hex[b[i]>>4]; out[i*2+1] = hex[b[i]&0x0f] }
-    return string(out)
+// allow spends a token from the client's bucket for the route class and
+// sets the RateLimit-* headers, plus Retry-After when the request is
+// refused. Limiter failures let the request through.
+func (s *Server) allow(w http.ResponseWriter, r *http.Request) bool {
+	if s.limiter == nil {
+		return true
+	}
+	class := routeClass(r)
+	rule := s.rateRule(class)
+	key := class + ":ip:" + s.clientIP(r)
+	if t, ok := s.keyedTenant(r); ok {
+		key = class + ":tenant:" + t.ID
+		if t.RateRPS > 0 {
+			rule = ratelimit.Rule{RPS: t.RateRPS, Burst: t.RateBurst}
+		}
+	}
+	if rule.RPS <= 0 {
+		return true
+	}
+	res, err := s.limiter.Allow(r.Context(), key, rule)
+	if err != nil {
+		if lg := s.componentLogger(r.Context(), s.cfg.RateLimitLog); lg != nil {
+			lg.Error("rate_limit_error", map[string]any{"route": class})
+		} else if s.log != nil {
+			s.logger(r.Context()).Error("rate_limit_error", map[string]any{"route": class})
+		}
+		return true
+	}
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+	}
+	return res.Allowed
 }
 
-func (s *Server) allow() bool {
-    if s.tokens == nil { return true }
-    select { case <-s.tokens: return true; default: return false }
+func (s *Server) rateRule(class string) ratelimit.Rule {
+	if class == routeCreate && s.cfg.CreateRateRPS > 0 {
+		return ratelimit.Rule{RPS: s.cfg.CreateRateRPS, Burst: s.cfg.CreateRateBurst}
+	}
+	return ratelimit.Rule{RPS: s.cfg.RateLimitRPS, Burst: s.cfg.RateBurst}
 }
 
-func (s *Server) generateCode(n int) string {
-	const letters = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
-	var b strings.Builder
-	for i := 0; i < n; i++ {
-		idx, _ := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
-		b.WriteByte(letters[idx.Int64()])
+const (
+	routeCreate = "create"
+	routeRead   = "read"
+)
+
+// routeClass tells creation requests, which consume storage, from the rest.
+func routeClass(r *http.Request) string {
+	if r.Method == http.MethodPost {
+		switch r.URL.Path {
+		case "/code", "/message", "/batch/messages":
+			return routeCreate
+		}
 	}
-	return b.String()
+	return routeRead
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+func (s *Server) generateCode() (string, error) {
+	return s.codes.Generate()
 }
 
 func (s *Server) postCode(w http.ResponseWriter, r *http.Request) {
@@ -119,26 +275,98 @@ func (s *Server) postCode(w http.ResponseWriter, r *http.Request) {
         w.WriteHeader(http.StatusMethodNotAllowed)
         return
     }
+    if !s.spendQuota(w, r, 1) {
+        return
+    }
     ctx := r.Context()
-	var code string
+	code, err := s.newCode(func(code string) (bool, error) {
+		return s.store.ReserveCode(ctx, code, s.cfg.PlaceholderTTL)
+	})
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("reserve_code_error", map[string]any{"endpoint": "code"})
+		}
+		s.refundQuota(r, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.indexCode(ctx, code)
+	s.audit(r, audit.Reserved, code)
+    s.writeCreated(w, code)
+}
+
+// createMessage stores the ciphertext under a fresh code in a single storage
+// call, so no attachable placeholder is ever exposed.
+func (s *Server) createMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ttl, ok := s.messageTTL(w, r)
+	if !ok {
+		return
+	}
+	ct, ok := s.readCiphertext(w, r, "message_post")
+	if !ok {
+		return
+	}
+	rs, readers, ok := s.readerPolicy(w, r, "message_post")
+	if !ok {
+		return
+	}
+	bs, rk, ok := s.readerKey(w, r, "message_post")
+	if !ok {
+		return
+	}
+	if !s.spendQuota(w, r, 1) {
+		return
+	}
+	ctx := r.Context()
+	code, err := s.newCode(func(code string) (bool, error) {
+		if rs != nil {
+			return rs.CreateRestrictedCipher(ctx, code, ct, *readers, ttl)
+		}
+		if bs != nil {
+			return bs.CreateBoundCipher(ctx, code, ct, rk, ttl)
+		}
+		return s.store.CreateCipher(ctx, code, ct, ttl)
+	})
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("create_cipher_error", map[string]any{"endpoint": "message_post"})
+		}
+		s.refundQuota(r, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.indexCode(ctx, code)
+	s.audit(r, audit.Created, code)
+	s.writeCreated(w, code)
+}
+
+// newCode generates codes until store accepts one.
+func (s *Server) newCode(store func(code string) (bool, error)) (string, error) {
 	for {
-		code = s.generateCode(8)
-		ok, err := s.store.ReserveCode(ctx, code, s.cfg.PlaceholderTTL)
+		code, err := s.generateCode()
 		if err != nil {
-			if s.log != nil {
-				s.log.Error("reserve_code_error", map[string]any{"endpoint": "code"})
-			}
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return "", err
+		}
+		ok, err := store(code)
+		if err != nil {
+			return "", err
 		}
 		if ok {
-			break
+			return code, nil
 		}
+		s.metrics.collisions.With().Inc()
 	}
-    w.Header().Set("Content-Type", "application/json")
-    w.Header().Set("Location", "/message/"+code)
-    w.WriteHeader(http.StatusCreated)
-    json.NewEncoder(w).Encode(map[string]string{"code": code})
+}
+
+func (s *Server) writeCreated(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/message/"+code)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"code": code})
 }
 
 func (s *Server) message(w http.ResponseWriter, r *http.Request) {
@@ -146,6 +374,41 @@ func (s *Server) message(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	code, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/message/"), "/")
+	if c, ok := s.codes.(codegen.Checker); ok && !c.Check(code) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPut && s.isPreviewBot(r) {
+		if s.log != nil {
+			s.logger(r.Context()).Info("preview_bot_refused", map[string]any{"endpoint": "message"})
+		}
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPut && s.lockedOut(w, r) {
+		return
+	}
+	if action == "reveal" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.revealMessage(w, r, code)
+		return
+	}
+	if action == "claim" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.claimMessage(w, r, code)
+		return
+	}
+	if action != "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	if r.Method == http.MethodPut {
 		s.putMessage(w, r)
 		return
@@ -158,82 +421,243 @@ func (s *Server) message(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) putMessage(w http.ResponseWriter, r *http.Request) {
-    s.secHeaders(w)
-    code := strings.TrimPrefix(r.URL.Path, "/message/")
-    max := s.cfg.MaxBodyBytes
-    if max <= 0 {
-        max = 1 << 20
-    }
-    r.Body = http.MaxBytesReader(w, r.Body, max)
-    body, err := io.ReadAll(r.Body)
-    if err != nil {
-        if s.log != nil {
-            s.log.Warn("body_read_error", map[string]any{"endpoint": "message_put"})
-        }
-        w.WriteHeader(http.StatusBadRequest)
-        return
-    }
-    ct := strings.TrimSpace(string(body))
-    if ct == "" {
-        if s.log != nil {
-            s.log.Warn("empty_body", map[string]any{"endpoint": "message_put"})
-        }
-        w.WriteHeader(http.StatusBadRequest)
-        return
-    }
-    buf, err := base64.StdEncoding.DecodeString(ct)
-    if err != nil || len(buf) < 13 {
-        if s.log != nil {
-            s.log.Warn("invalid_base64", map[string]any{"endpoint": "message_put"})
-        }
-        w.WriteHeader(http.StatusBadRequest)
-        return
-    }
-    iv := buf[:12]
-    if len(iv) != 12 || len(buf[12:]) == 0 {
-        if s.log != nil {
-            s.log.Warn("invalid_iv", map[string]any{"endpoint": "message_put"})
-        }
-        w.WriteHeader(http.StatusBadRequest)
-        return
-    }
+	s.secHeaders(w)
+	code := strings.TrimPrefix(r.URL.Path, "/message/")
+	ttl, valid := s.messageTTL(w, r)
+	if !valid {
+		return
+	}
+	ct, valid := s.readCiphertext(w, r, "message_put")
+	if !valid {
+		return
+	}
 
-    ok, err := s.store.AttachCipher(r.Context(), code, ct, s.cfg.MessageTTL)
-    if err != nil {
-        if s.log != nil {
-            s.log.Error("attach_cipher_error", map[string]any{"endpoint": "message_put"})
+	var ok bool
+	var err error
+	rs, readers, valid := s.readerPolicy(w, r, "message_put")
+	if !valid {
+		return
+	}
+	bs, rk, valid := s.readerKey(w, r, "message_put")
+	if !valid {
+		return
+	}
+	if rs != nil {
+		ok, err = rs.AttachRestrictedCipher(r.Context(), code, ct, *readers, ttl)
+	} else if bs != nil {
+		ok, err = bs.AttachBoundCipher(r.Context(), code, ct, rk, ttl)
+	} else {
+		ok, err = s.store.AttachCipher(r.Context(), code, ct, ttl)
+	}
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("attach_cipher_error", map[string]any{"endpoint": "message_put"})
 		}
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	if !ok {
 		if s.log != nil {
-			s.log.Warn("attach_conflict", map[string]any{"endpoint": "message_put"})
+			s.logger(r.Context()).Warn("attach_conflict", map[string]any{"endpoint": "message_put"})
 		}
 		w.WriteHeader(http.StatusConflict)
 		return
 	}
+	s.audit(r, audit.Created, code)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// readCiphertext reads and checks the request body; on failure the response
+// has already been written.
+func (s *Server) readCiphertext(w http.ResponseWriter, r *http.Request, endpoint string) (string, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBody(r))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("body_read_error", map[string]any{"endpoint": endpoint})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return "", false
+	}
+	ct := strings.TrimSpace(string(body))
+	if e := cipherError(ct); e != "" {
+		if s.log != nil {
+			s.logger(r.Context()).Warn(e, map[string]any{"endpoint": endpoint})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return "", false
+	}
+	s.metrics.messageSize.With(endpoint).Observe(float64(len(ct)))
+	return ct, true
+}
+
+// cipherError names what is wrong with a base64 IV(12B)+ciphertext payload,
+// or returns "" when it is well formed.
+func cipherError(ct string) string {
+	if ct == "" {
+		return "empty_body"
+	}
+	buf, err := base64.StdEncoding.DecodeString(ct)
+	if err != nil || len(buf) < 13 {
+		return "invalid_base64"
+	}
+	iv := buf[:12]
+	if len(iv) != 12 || len(buf[12:]) == 0 {
+		return "invalid_iv"
+	}
+	return ""
+}
+
 func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) {
     code := strings.TrimPrefix(r.URL.Path, "/message/")
+    if bs, ok := s.store.(storage.BoundStore); ok {
+        _, bound, err := bs.ReaderKey(r.Context(), code)
+        if err != nil {
+            if s.log != nil {
+                s.logger(r.Context()).Error("reader_key_error", map[string]any{"endpoint": "message_get"})
+            }
+            w.WriteHeader(http.StatusInternalServerError)
+            return
+        }
+        if bound {
+            s.issueChallenge(w, r, bs, code)
+            return
+        }
+    }
+    if s.cfg.RevealMode {
+        s.offerReveal(w, r, code)
+        return
+    }
+    s.writeMessage(w, r, code)
+}
+
+func (s *Server) writeMessage(w http.ResponseWriter, r *http.Request, code string) {
+    if rs, ok := s.store.(storage.RestrictedStore); ok {
+        s.writeRestricted(w, r, rs, code)
+        return
+    }
     ct, ok, err := s.store.GetAndDelete(r.Context(), code)
     if err != nil {
         if s.log != nil {
-            s.log.Error("get_delete_error", map[string]any{"endpoint": "message_get"})
+            s.logger(r.Context()).Error("get_delete_error", map[string]any{"endpoint": "message_get"})
         }
         w.WriteHeader(http.StatusInternalServerError)
         return
     }
     if !ok {
+        s.recordMiss(r, code)
         w.WriteHeader(http.StatusNotFound)
         return
     }
+    s.audit(r, audit.Read, code)
     w.Header().Set("Content-Type", "text/plain")
     w.Write([]byte(ct))
 }
 
+func (s *Server) challengeTTL() time.Duration {
+	if s.cfg.ChallengeTTL <= 0 {
+		return time.Minute
+	}
+	return s.cfg.ChallengeTTL
+}
+
+func (s *Server) issueChallenge(w http.ResponseWriter, r *http.Request, bs storage.BoundStore, code string) {
+	ttl := s.challengeTTL()
+	var b [32]byte
+	rand.Read(b[:])
+	nonce := base64.RawURLEncoding.EncodeToString(b[:])
+	if err := bs.PutChallenge(r.Context(), code, nonce, ttl); err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("put_challenge_error", map[string]any{"endpoint": "message_get"})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Ed25519 realm="message"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]any{"challenge": nonce, "expires_in": int(ttl / time.Second)})
+}
+
+// claimMessage releases a bound message when the body carries an Ed25519
+// signature over "<code>:<challenge>" made with the reader key.
+func (s *Server) claimMessage(w http.ResponseWriter, r *http.Request, code string) {
+	bs, ok := s.store.(storage.BoundStore)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	var req struct {
+		Challenge string `json:"challenge"`
+		Signature string `json:"signature"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Challenge == "" || req.Signature == "" {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("invalid_claim", map[string]any{"endpoint": "message_claim"})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sig, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	taken, err := bs.TakeChallenge(ctx, code, req.Challenge)
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("take_challenge_error", map[string]any{"endpoint": "message_claim"})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !taken {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	rk, bound, err := bs.ReaderKey(ctx, code)
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("reader_key_error", map[string]any{"endpoint": "message_claim"})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !bound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	pub, err := base64.StdEncoding.DecodeString(rk)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), []byte(code+":"+req.Challenge), sig) {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("claim_bad_signature", map[string]any{"endpoint": "message_claim"})
+		}
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	ct, ok, err := bs.ClaimBound(ctx, code, rk)
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("claim_error", map[string]any{"endpoint": "message_claim"})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.audit(r, audit.Read, code)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(ct))
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
     st := "ok"
     if err := s.store.Ping(r.Context()); err != nil { st = "error" }
@@ -270,5 +694,33 @@ func (s *Server) Start(ctx context.Context) error {
 		defer cancel()
 		srv.Shutdown(c)
 	}()
-	return srv.ListenAndServe()
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	return srv.Serve(ln)
+}
+
+// listen opens the TCP listener, reading PROXY protocol headers beneath
+// TLS when ProxyProtocolFrom is set.
+func (s *Server) listen() (net.Listener, error) {
+	addr := s.cfg.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.cfg.ProxyProtocolFrom) > 0 {
+		ln = &proxyproto.Listener{Listener: ln, Trusted: s.cfg.ProxyProtocolFrom, Optional: s.cfg.ProxyProtocolOptional}
+	}
+	if s.cfg.TLS != nil {
+		cfg := s.cfg.TLS.Clone()
+		if len(cfg.NextProtos) == 0 {
+			cfg.NextProtos = []string{"h2", "http/1.1"}
+		}
+		ln = tls.NewListener(ln, cfg)
+	}
+	return ln, nil
 }
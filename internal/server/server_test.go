@@ -1,23 +1,58 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"backend_msgs_golang/internal/acl"
+	"backend_msgs_golang/internal/audit"
+	"backend_msgs_golang/internal/captcha"
+	"backend_msgs_golang/internal/codegen"
+	"backend_msgs_golang/internal/events"
+	applog "backend_msgs_golang/internal/log"
+	"backend_msgs_golang/internal/metrics"
+	"backend_msgs_golang/internal/netutil"
+	"backend_msgs_golang/internal/oidc"
+	"backend_msgs_golang/internal/pow"
+	"backend_msgs_golang/internal/reqid"
+	"backend_msgs_golang/internal/storage"
+	"backend_msgs_golang/internal/tenant"
+	"backend_msgs_golang/internal/trace"
 )
 
 type mockStore struct {
 	reserveOK bool
 	attachOK  bool
 	attachErr error
+	createOK  bool
+	createErr error
 	getVal    string
 	getOK     bool
 	getErr    error
 	pingErr   error
+	observer  storage.Observer
 }
 
 func (m *mockStore) ReserveCode(_ context.Context, code string, ttl time.Duration) (bool, error) {
@@ -26,7 +61,13 @@ func (m *mockStore) ReserveCode(_ context.Context, code string, ttl time.Duratio
 func (m *mockStore) AttachCipher(_ context.Context, code string, ciphertext string, ttl time.Duration) (bool, error) {
 	return m.attachOK, m.attachErr
 }
-func (m *mockStore) GetAndDelete(_ context.Context, code string) (string, bool, error) {
+func (m *mockStore) CreateCipher(_ context.Context, code string, ciphertext string, ttl time.Duration) (bool, error) {
+	return m.createOK, m.createErr
+}
+func (m *mockStore) GetAndDelete(ctx context.Context, code string) (string, bool, error) {
+	if m.observer != nil {
+		m.observer.Observe(ctx, "GetAndDelete")(m.getErr)
+	}
 	return m.getVal, m.getOK, m.getErr
 }
 func (m *mockStore) Ping(_ context.Context) error { return m.pingErr }
@@ -59,6 +100,30 @@ func TestPostCode201(t *testing.T) {
 	}
 }
 
+func TestPostMessage201(t *testing.T) {
+	server := newTestServer(&mockStore{createOK: true})
+	body := base64.StdEncoding.EncodeToString(append(make([]byte, 12), []byte("abc")...))
+	request := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", recorder.Code)
+	}
+	if !strings.HasPrefix(recorder.Header().Get("Location"), "/message/") {
+		t.Fatalf("missing Location header")
+	}
+}
+
+func TestPostMessageBadBase64(t *testing.T) {
+	server := newTestServer(&mockStore{createOK: true})
+	request := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader("%%%"))
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", recorder.Code)
+	}
+}
+
 func TestPutMessageValid(t *testing.T) {
 	store := &mockStore{attachOK: true}
 	server := newTestServer(store)
@@ -188,18 +253,1107 @@ func TestRateLimit(t *testing.T) {
 	cfg := Config{RateLimitRPS: 1, RateBurst: 1}
 	logger := &nopLogger{}
 	server := New(cfg, store, logger)
-	server.tokens = make(chan struct{}, 1)
-	server.tokens <- struct{}{}
 	firstRequest := httptest.NewRequest(http.MethodPost, "/code", nil)
 	firstRecorder := httptest.NewRecorder()
 	server.Handler().ServeHTTP(firstRecorder, firstRequest)
 	if firstRecorder.Code == http.StatusTooManyRequests {
 		t.Fatalf("rate limited unexpectedly")
 	}
+	if firstRecorder.Header().Get("RateLimit-Limit") != "1" || firstRecorder.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("missing RateLimit headers")
+	}
 	secondRequest := httptest.NewRequest(http.MethodPost, "/code", nil)
 	secondRecorder := httptest.NewRecorder()
 	server.Handler().ServeHTTP(secondRecorder, secondRequest)
 	if secondRecorder.Code != http.StatusTooManyRequests {
 		t.Fatalf("expected 429, got %d", secondRecorder.Code)
 	}
+	if secondRecorder.Header().Get("Retry-After") == "" {
+		t.Fatalf("missing Retry-After header")
+	}
+	otherRequest := httptest.NewRequest(http.MethodPost, "/code", nil)
+	otherRequest.RemoteAddr = "198.51.100.7:4321"
+	otherRecorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(otherRecorder, otherRequest)
+	if otherRecorder.Code == http.StatusTooManyRequests {
+		t.Fatalf("other client limited by shared quota")
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	trusted, _ := netutil.ParseCIDRs([]string{"10.0.0.0/8"})
+	server := New(Config{TrustedProxies: trusted}, &mockStore{}, &nopLogger{})
+	cases := []struct {
+		remote, xff, forwarded, want string
+	}{
+		{"203.0.113.9:1000", "1.1.1.1", "", "203.0.113.9"},
+		{"10.0.0.1:1000", "1.1.1.1, 2.2.2.2, 10.0.0.2", "", "2.2.2.2"},
+		{"10.0.0.1:1000", "", `for="[2001:db8::1]:4711";proto=https`, "2001:db8::1"},
+		{"10.0.0.1:1000", "", "", "10.0.0.1"},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/health", nil)
+		request.RemoteAddr = c.remote
+		if c.xff != "" {
+			request.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if c.forwarded != "" {
+			request.Header.Set("Forwarded", c.forwarded)
+		}
+		if got := server.clientIP(request); got != c.want {
+			t.Fatalf("%+v: got %s", c, got)
+		}
+	}
+}
+
+type boundStore struct {
+	mockStore
+	readerKey  string
+	challenges map[string]bool
+	boundCT    string
+}
+
+func (b *boundStore) AttachBoundCipher(_ context.Context, code string, ciphertext string, readerKey string, ttl time.Duration) (bool, error) {
+	if b.attachErr != nil {
+		return false, b.attachErr
+	}
+	b.readerKey = readerKey
+	b.boundCT = ciphertext
+	return true, nil
+}
+func (b *boundStore) CreateBoundCipher(_ context.Context, code string, ciphertext string, readerKey string, ttl time.Duration) (bool, error) {
+	b.readerKey = readerKey
+	b.boundCT = ciphertext
+	return true, nil
+}
+func (b *boundStore) ClaimBound(_ context.Context, code string, readerKey string) (string, bool, error) {
+	if b.readerKey == "" || b.readerKey != readerKey {
+		return "", false, nil
+	}
+	b.readerKey = ""
+	return b.getVal, b.getVal != "", nil
+}
+func (b *boundStore) ReaderKey(_ context.Context, code string) (string, bool, error) {
+	return b.readerKey, b.readerKey != "", nil
+}
+func (b *boundStore) PutChallenge(_ context.Context, code string, nonce string, ttl time.Duration) error {
+	if b.challenges == nil {
+		b.challenges = map[string]bool{}
+	}
+	b.challenges[code+":"+nonce] = true
+	return nil
+}
+func (b *boundStore) TakeChallenge(_ context.Context, code string, nonce string) (bool, error) {
+	ok := b.challenges[code+":"+nonce]
+	delete(b.challenges, code+":"+nonce)
+	return ok, nil
+}
+
+func TestBoundMessageClaim(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	store := &boundStore{mockStore: mockStore{getVal: "abc", getOK: true}}
+	server := New(Config{}, store, &nopLogger{})
+
+	body := base64.StdEncoding.EncodeToString(append(make([]byte, 12), 'x'))
+	put := httptest.NewRequest(http.MethodPut, "/message/xyz", strings.NewReader(body))
+	put.Header.Set("X-Reader-Key", base64.StdEncoding.EncodeToString(pub))
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, put)
+	if recorder.Code != http.StatusNoContent || store.readerKey == "" {
+		t.Fatalf("expected bound attach, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/message/xyz", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", recorder.Code)
+	}
+	var challenge struct {
+		Challenge string `json:"challenge"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&challenge)
+
+	claim := func(sig []byte) int {
+		payload, _ := json.Marshal(map[string]string{"challenge": challenge.Challenge, "signature": base64.StdEncoding.EncodeToString(sig)})
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/message/xyz/claim", bytes.NewReader(payload)))
+		return recorder.Code
+	}
+	_, otherPriv, _ := ed25519.GenerateKey(nil)
+	if code := claim(ed25519.Sign(otherPriv, []byte("xyz:"+challenge.Challenge))); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for wrong key, got %d", code)
+	}
+	// the failed attempt consumed the challenge
+	if code := claim(ed25519.Sign(priv, []byte("xyz:"+challenge.Challenge))); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for reused challenge, got %d", code)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/message/xyz", nil))
+	json.NewDecoder(recorder.Body).Decode(&challenge)
+	if code := claim(ed25519.Sign(priv, []byte("xyz:"+challenge.Challenge))); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+}
+
+func TestPostMessageBound(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	store := &boundStore{}
+	server := New(Config{}, store, &nopLogger{})
+
+	body := base64.StdEncoding.EncodeToString(append(make([]byte, 12), 'x'))
+	post := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body))
+	post.Header.Set("X-Reader-Key", base64.StdEncoding.EncodeToString(pub))
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, post)
+	if recorder.Code != http.StatusCreated || store.readerKey == "" || store.boundCT != body {
+		t.Fatalf("expected bound create, got %d", recorder.Code)
+	}
+
+	post = httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body))
+	post.Header.Set("X-Reader-Key", "short")
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, post)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid key, got %d", recorder.Code)
+	}
+
+	// a store without key binding must not create an unbound message
+	server = New(Config{}, &mockStore{createOK: true}, &nopLogger{})
+	post = httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body))
+	post.Header.Set("X-Reader-Key", base64.StdEncoding.EncodeToString(pub))
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, post)
+	if recorder.Code != http.StatusNotImplemented {
+		t.Fatalf("expected 501, got %d", recorder.Code)
+	}
+}
+
+func TestBoundAttachStorageError(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	store := &boundStore{mockStore: mockStore{attachErr: errors.New("boom")}}
+	server := New(Config{}, store, &nopLogger{})
+
+	body := base64.StdEncoding.EncodeToString(append(make([]byte, 12), 'x'))
+	put := httptest.NewRequest(http.MethodPut, "/message/xyz", strings.NewReader(body))
+	put.Header.Set("X-Reader-Key", base64.StdEncoding.EncodeToString(pub))
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, put)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", recorder.Code)
+	}
+}
+
+func TestRevealMode(t *testing.T) {
+	store := &boundStore{mockStore: mockStore{getVal: "abc", getOK: true}}
+	cfg := Config{RevealMode: true, RevealNonce: true, PreviewBotAgents: DefaultPreviewBotAgents}
+	server := New(cfg, store, &nopLogger{})
+
+	bot := httptest.NewRequest(http.MethodGet, "/message/xyz", nil)
+	bot.Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, bot)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for preview bot, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/message/xyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	var offer struct {
+		Reveal string `json:"reveal"`
+		Nonce  string `json:"nonce"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&offer)
+	if offer.Reveal != "/message/xyz/reveal" || offer.Nonce == "" {
+		t.Fatalf("unexpected reveal offer: %+v", offer)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, offer.Reveal, strings.NewReader(`{"nonce":"bogus"}`)))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for unknown nonce, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, offer.Reveal, strings.NewReader(`{"nonce":"`+offer.Nonce+`"}`)))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "abc" {
+		t.Fatalf("expected ciphertext, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+type batchStore struct {
+	mockStore
+	created []storage.NewMessage
+}
+
+func (b *batchStore) CreateCiphers(_ context.Context, msgs []storage.NewMessage) ([]bool, error) {
+	out := make([]bool, len(msgs))
+	for i, m := range msgs {
+		b.created = append(b.created, m)
+		out[i] = true
+	}
+	return out, nil
+}
+
+func TestPostBatch(t *testing.T) {
+	store := &batchStore{}
+	server := New(Config{MessageTTL: time.Hour, BatchMaxItems: 3}, store, &nopLogger{})
+	valid := base64.StdEncoding.EncodeToString(append(make([]byte, 12), 'x'))
+	body := `{"messages":[{"ciphertext":"` + valid + `","ttl":60,"views":2},{"ciphertext":"%%%"},{"ciphertext":"` + valid + `","ttl":7200}]}`
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/batch/messages", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	var resp struct {
+		Results []batchResult `json:"results"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&resp)
+	if len(resp.Results) != 3 || resp.Results[0].Code == "" || resp.Results[1].Error != "invalid_base64" || resp.Results[2].Error != "invalid_ttl" {
+		t.Fatalf("unexpected results: %+v", resp.Results)
+	}
+	if len(store.created) != 1 || store.created[0].TTL != time.Minute || store.created[0].Views != 2 {
+		t.Fatalf("unexpected stored messages: %+v", store.created)
+	}
+
+	body = `{"messages":[{},{},{},{}]}`
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/batch/messages", strings.NewReader(body)))
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", recorder.Code)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/batch/messages", strings.NewReader(`{"messages":[{}]}`))
+	request.Header.Set("X-Reader-Key", base64.StdEncoding.EncodeToString(make([]byte, ed25519.PublicKeySize)))
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for reader key on batch, got %d", recorder.Code)
+	}
+}
+
+func TestChecksumRejectsBeforeStorage(t *testing.T) {
+	codes, _ := codegen.New(codegen.Options{Checksum: true})
+	server := New(Config{Codes: codes}, &mockStore{getVal: "abc", getOK: true}, &nopLogger{})
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/message/xyz", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", recorder.Code)
+	}
+	code, _ := codes.Generate()
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/message/"+code, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+}
+
+type guessStore struct {
+	mockStore
+	misses  int
+	indexed []string
+	lock    time.Duration
+	burned  int
+}
+
+func (g *guessStore) IndexCode(_ context.Context, code string, prefix string, ttl time.Duration) error {
+	g.indexed = append(g.indexed, prefix)
+	return nil
+}
+func (g *guessStore) Lockout(_ context.Context, client string) (time.Duration, error) {
+	return g.lock, nil
+}
+func (g *guessStore) RecordMiss(_ context.Context, client string, prefix string, p storage.GuessPolicy) (time.Duration, int, error) {
+	g.misses++
+	if g.misses >= p.Limit {
+		g.lock = p.Lockout
+	}
+	return g.lock, g.burned, nil
+}
+
+func TestGuessLockout(t *testing.T) {
+	store := &guessStore{mockStore: mockStore{reserveOK: true}}
+	codes, _ := codegen.New(codegen.Options{Strategy: "digits"})
+	cfg := Config{Codes: codes, GuessLimit: 2, GuessLockout: 30 * time.Second, GuessBurnAfter: 5}
+	server := New(cfg, store, &nopLogger{})
+
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/code", nil))
+	if len(store.indexed) != 1 || len(store.indexed[0]) != 5 {
+		t.Fatalf("expected code indexed by 5-digit prefix, got %v", store.indexed)
+	}
+
+	for i, want := range []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests} {
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/message/123456", nil))
+		if recorder.Code != want {
+			t.Fatalf("request %d: expected %d, got %d", i, want, recorder.Code)
+		}
+	}
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/message/123456", nil))
+	if recorder.Header().Get("Retry-After") != "30" {
+		t.Fatalf("expected Retry-After 30, got %q", recorder.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitPerRoute(t *testing.T) {
+	cfg := Config{RateLimitRPS: 100, CreateRateRPS: 1, CreateRateBurst: 1}
+	server := New(cfg, &mockStore{reserveOK: true, getOK: true, getVal: "abc"}, &nopLogger{})
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/code", nil))
+		codes = append(codes, recorder.Code)
+	}
+	if codes[0] != http.StatusCreated || codes[1] != http.StatusTooManyRequests {
+		t.Fatalf("unexpected creation statuses %v", codes)
+	}
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/message/xyz", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("RateLimit-Limit") != "100" {
+		t.Fatalf("read budget not applied: %d", recorder.Code)
+	}
+}
+
+type replayStore struct {
+	mockStore
+	used map[string]bool
+}
+
+func (r *replayStore) Consume(_ context.Context, id string, ttl time.Duration) (bool, error) {
+	if r.used[id] {
+		return false, nil
+	}
+	r.used[id] = true
+	return true, nil
+}
+
+func TestPowRequiredForCreation(t *testing.T) {
+	store := &replayStore{mockStore: mockStore{reserveOK: true}, used: map[string]bool{}}
+	issuer := &pow.Issuer{Key: []byte("0123456789abcdef"), TTL: time.Minute, BaseBits: 4}
+	server := New(Config{Pow: issuer}, store, &nopLogger{})
+
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/code", nil))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without pow, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/challenge/pow", nil))
+	var ch struct {
+		Challenge  string `json:"challenge"`
+		Difficulty int    `json:"difficulty"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&ch)
+	if ch.Challenge == "" || ch.Difficulty != 4 {
+		t.Fatalf("unexpected challenge %+v", ch)
+	}
+	solution := ""
+	for i := 0; solution == ""; i++ {
+		if pow.LeadingZeros(ch.Challenge, strconv.Itoa(i)) >= ch.Difficulty {
+			solution = strconv.Itoa(i)
+		}
+	}
+	post := func() int {
+		request := httptest.NewRequest(http.MethodPost, "/code", nil)
+		request.Header.Set("X-Pow-Challenge", ch.Challenge)
+		request.Header.Set("X-Pow-Solution", solution)
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := post(); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	if code := post(); code != http.StatusForbidden {
+		t.Fatalf("expected replay to be refused, got %d", code)
+	}
+}
+
+type stubVerifier struct{ err error }
+
+func (v stubVerifier) Verify(_ context.Context, token, remoteIP string) error {
+	if token != "good" {
+		return captcha.ErrFailed
+	}
+	return v.err
+}
+
+func TestCaptchaOnCreation(t *testing.T) {
+	server := New(Config{Captcha: stubVerifier{}}, &mockStore{reserveOK: true}, &nopLogger{})
+	post := func(token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/code", nil)
+		if token != "" {
+			request.Header.Set("X-Captcha-Token", token)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder
+	}
+	if recorder := post(""); recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), "captcha_required") {
+		t.Fatalf("expected captcha_required, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := post("bad"); recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), "captcha_failed") {
+		t.Fatalf("expected captcha_failed, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := post("good"); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", recorder.Code)
+	}
+	if server.captcha.Failed.Load() != 1 || server.captcha.Passed.Load() != 1 {
+		t.Fatalf("unexpected captcha counters")
+	}
+
+	lenient := New(Config{Captcha: stubVerifier{}, CaptchaAboveRate: 1000}, &mockStore{reserveOK: true}, &nopLogger{})
+	recorder := httptest.NewRecorder()
+	lenient.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/code", nil))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("captcha required below threshold: %d", recorder.Code)
+	}
+}
+
+type tenantStore struct {
+	mockStore
+	tenants map[string]tenant.Tenant
+	keys    map[string]string
+	quota   map[string]int64
+}
+
+func newTenantStore() *tenantStore {
+	return &tenantStore{mockStore: mockStore{reserveOK: true, createOK: true}, tenants: map[string]tenant.Tenant{}, keys: map[string]string{}, quota: map[string]int64{}}
+}
+func (ts *tenantStore) SaveTenant(_ context.Context, t tenant.Tenant) error {
+	ts.tenants[t.ID] = t
+	return nil
+}
+func (ts *tenantStore) SaveAPIKey(_ context.Context, keyHash string, tenantID string) error {
+	ts.keys[keyHash] = tenantID
+	return nil
+}
+func (ts *tenantStore) LookupAPIKey(_ context.Context, keyHash string) (tenant.Tenant, bool, error) {
+	id, ok := ts.keys[keyHash]
+	return ts.tenants[id], ok, nil
+}
+func (ts *tenantStore) AddQuota(_ context.Context, tenantID string, day string, n int64) (int64, error) {
+	ts.quota[tenantID+day] += n
+	return ts.quota[tenantID+day], nil
+}
+
+func TestAPIKeyTenants(t *testing.T) {
+	store := newTenantStore()
+	cfg := Config{MessageTTL: time.Hour, Tenants: store, AdminToken: "admin", Pow: &pow.Issuer{Key: []byte("0123456789abcdef"), BaseBits: 30}}
+	server := New(cfg, store, &nopLogger{})
+
+	provision := httptest.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(`{"id":"acme","daily_quota":1,"max_ttl":60}`))
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, provision)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without admin token, got %d", recorder.Code)
+	}
+	provision = httptest.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(`{"id":"acme","daily_quota":1,"max_ttl":60}`))
+	provision.Header.Set("Authorization", "Bearer admin")
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, provision)
+	var issued struct {
+		Key string `json:"key"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&issued)
+	if recorder.Code != http.StatusCreated || !strings.HasPrefix(issued.Key, "sk_") {
+		t.Fatalf("provisioning failed: %d", recorder.Code)
+	}
+	if _, stored := store.keys[issued.Key]; stored {
+		t.Fatalf("api key stored in clear")
+	}
+
+	body := base64.StdEncoding.EncodeToString(append(make([]byte, 12), 'x'))
+	create := func(key, ttl string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body))
+		if key != "" {
+			request.Header.Set("X-Api-Key", key)
+		}
+		if ttl != "" {
+			request.Header.Set("X-Message-TTL", ttl)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder
+	}
+	if recorder := create("", ""); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected anonymous creation refused, got %d", recorder.Code)
+	}
+	if recorder := create("sk_unknown", ""); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unknown key refused, got %d", recorder.Code)
+	}
+	if recorder := create(issued.Key, "3600"); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected TTL above tenant bound refused, got %d", recorder.Code)
+	}
+	// the API key skips the proof of work required from anonymous callers
+	if recorder := create(issued.Key, "30"); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", recorder.Code)
+	}
+	if recorder := create(issued.Key, ""); recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected quota exceeded, got %d", recorder.Code)
+	}
+	if used := store.quota["acme"+tenant.Day(time.Now())]; used != 1 {
+		t.Fatalf("refused creation still charged: %d", used)
+	}
+
+	// a storage failure is refunded
+	store.quota = map[string]int64{}
+	store.createErr = errors.New("boom")
+	if recorder := create(issued.Key, ""); recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", recorder.Code)
+	}
+	store.createErr = nil
+	if recorder := create(issued.Key, ""); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected quota refunded after failure, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/message/xyz", nil))
+	if recorder.Code == http.StatusUnauthorized {
+		t.Fatalf("reads must stay anonymous")
+	}
+}
+
+func TestTenantTTLWithinSignedCodes(t *testing.T) {
+	store := newTenantStore()
+	store.tenants["long"] = tenant.Tenant{ID: "long", MaxTTL: 7 * 24 * 3600}
+	store.keys[tenant.HashKey("sk_long")] = "long"
+	codes, _ := codegen.New(codegen.Options{Strategy: "signed", Keys: map[byte][]byte{1: []byte("0123456789abcdef")}, ActiveKID: 1, TTL: time.Minute + time.Hour})
+	server := New(Config{PlaceholderTTL: time.Minute, MessageTTL: time.Hour, Tenants: store, Codes: codes}, store, &nopLogger{})
+
+	body := base64.StdEncoding.EncodeToString(append(make([]byte, 12), 'x'))
+	create := func(ttl string) int {
+		request := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body))
+		request.Header.Set("X-Api-Key", "sk_long")
+		request.Header.Set("X-Message-TTL", ttl)
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := create("7200"); code != http.StatusBadRequest {
+		t.Fatalf("expected TTL beyond code lifetime refused, got %d", code)
+	}
+	if code := create("3600"); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+}
+
+func TestCodeLifetimeFailsClosed(t *testing.T) {
+	codes, _ := codegen.New(codegen.Options{Strategy: "signed", Keys: map[byte][]byte{1: []byte("0123456789abcdef")}, ActiveKID: 1, TTL: time.Minute})
+	server := New(Config{PlaceholderTTL: time.Hour, MessageTTL: time.Hour, Codes: codes}, &mockStore{}, &nopLogger{})
+	if _, max := server.ttlBounds(httptest.NewRequest(http.MethodPost, "/message", nil)); max != time.Second {
+		t.Fatalf("expected the cap to stay in place, got %v", max)
+	}
+}
+
+func TestOIDCCreators(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{"kty": "EC", "kid": "k1", "crv": "P-256", "x": b64(x), "y": b64(y)}}})
+	}))
+	defer jwks.Close()
+	token := func(groups ...string) string {
+		h, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+		p, _ := json.Marshal(map[string]any{"iss": "https://idp.example", "aud": "msgs", "sub": "employee-7", "exp": time.Now().Add(time.Minute).Unix(), "groups": groups})
+		input := b64(h) + "." + b64(p)
+		digest := sha256.Sum256([]byte(input))
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return input + "." + b64(sig)
+	}
+
+	verifier := &oidc.Verifier{Issuer: "https://idp.example", Audience: "msgs", JWKSURL: jwks.URL, RequiredGroups: []string{"staff"}}
+	server := New(Config{OIDC: verifier, Pow: &pow.Issuer{Key: []byte("k")}}, &mockStore{reserveOK: true, getVal: "ct", getOK: true}, &nopLogger{})
+	send := func(method, path, auth string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		if auth != "" {
+			request.Header.Set("Authorization", "Bearer "+auth)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder
+	}
+	if recorder := send(http.MethodPost, "/code", ""); recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Body.String(), "token_required") {
+		t.Fatalf("expected token_required, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := send(http.MethodPost, "/code", token("staff")[1:]); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a broken token, got %d", recorder.Code)
+	}
+	if recorder := send(http.MethodPost, "/code", token("guests")); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without the required group, got %d", recorder.Code)
+	}
+	if recorder := send(http.MethodPost, "/code", token("staff")); recorder.Code != http.StatusCreated {
+		t.Fatalf("expected 201 with a valid token, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := send(http.MethodGet, "/message/abcdefgh", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("readers must stay anonymous, got %d", recorder.Code)
+	}
+}
+
+func TestClientCertCreators(t *testing.T) {
+	server := New(Config{Pow: &pow.Issuer{Key: []byte("k")}, ClientCertCreators: []string{"ops-runner"}}, &mockStore{reserveOK: true}, &nopLogger{})
+	post := func(cn string) int {
+		request := httptest.NewRequest(http.MethodPost, "/code", nil)
+		if cn != "" {
+			leaf := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+			request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := post("ops-runner"); code != http.StatusCreated {
+		t.Fatalf("expected 201 for an allowed client certificate, got %d", code)
+	}
+	if code := post("someone-else"); code != http.StatusForbidden {
+		t.Fatalf("expected other certificates to need a challenge, got %d", code)
+	}
+	if code := post(""); code != http.StatusForbidden {
+		t.Fatalf("expected plain requests to need a challenge, got %d", code)
+	}
+}
+
+func TestACLPerRouteClass(t *testing.T) {
+	policy, err := acl.Parse(strings.NewReader("allow create 10.0.0.0/8\ndeny * 198.51.100.0/24\n"), RouteClasses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxies, _ := netutil.ParseCIDRs([]string{"192.0.2.1"})
+	list := acl.NewList(policy)
+	server := New(Config{ACL: list, TrustedProxies: proxies}, &mockStore{reserveOK: true, getVal: "ct", getOK: true}, &nopLogger{})
+	send := func(method, path, forwarded string) int {
+		request := httptest.NewRequest(method, path, nil)
+		request.RemoteAddr = "192.0.2.1:4000"
+		request.Header.Set("X-Forwarded-For", forwarded)
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := send(http.MethodPost, "/code", "10.1.2.3"); code != http.StatusCreated {
+		t.Fatalf("expected corporate creation to pass, got %d", code)
+	}
+	if code := send(http.MethodPost, "/code", "203.0.113.5"); code != http.StatusForbidden {
+		t.Fatalf("expected outside creation to be refused, got %d", code)
+	}
+	if code := send(http.MethodGet, "/message/abcdefgh", "203.0.113.5"); code != http.StatusOK {
+		t.Fatalf("expected anyone to read, got %d", code)
+	}
+	if code := send(http.MethodGet, "/message/abcdefgh", "198.51.100.9"); code != http.StatusForbidden {
+		t.Fatalf("expected denied range to be refused, got %d", code)
+	}
+	if code := send(http.MethodPut, "/message/abcdefgh", "203.0.113.5"); code != http.StatusForbidden {
+		t.Fatalf("expected outside attach to be refused, got %d", code)
+	}
+	if code := send(http.MethodGet, "/admin/log-level", "10.1.2.3"); code == http.StatusForbidden {
+		t.Fatalf("expected admin class open without rules, got %d", code)
+	}
+	policy.Add("allow", "admin", []string{"10.0.0.0/8"}, RouteClasses)
+	list.Set(policy)
+	if code := send(http.MethodGet, "/admin/log-level", "203.0.113.5"); code != http.StatusForbidden {
+		t.Fatalf("expected outside admin call to be refused, got %d", code)
+	}
+	list.Set(acl.Policy{})
+	if code := send(http.MethodPost, "/code", "203.0.113.5"); code != http.StatusCreated {
+		t.Fatalf("expected swapped policy to apply, got %d", code)
+	}
+}
+
+type restrictedStore struct {
+	mockStore
+	policy  storage.ReaderPolicy
+	ct      string
+	refused int
+}
+
+func (rs *restrictedStore) AttachRestrictedCipher(_ context.Context, code string, ct string, p storage.ReaderPolicy, _ time.Duration) (bool, error) {
+	rs.ct, rs.policy = ct, p
+	return true, nil
+}
+func (rs *restrictedStore) CreateRestrictedCipher(ctx context.Context, code string, ct string, p storage.ReaderPolicy, ttl time.Duration) (bool, error) {
+	return rs.AttachRestrictedCipher(ctx, code, ct, p, ttl)
+}
+func (rs *restrictedStore) ReadFrom(_ context.Context, code string, client net.IP) (string, storage.ReadResult, error) {
+	if rs.ct == "" {
+		return "", storage.ReadMissing, nil
+	}
+	if !netutil.Contains(rs.policy.Networks, client) {
+		rs.refused++
+		if rs.refused >= rs.policy.BurnAfter {
+			rs.ct = ""
+			return "", storage.ReadBurned, nil
+		}
+		return "", storage.ReadRefused, nil
+	}
+	ct := rs.ct
+	rs.ct = ""
+	return ct, storage.ReadOK, nil
+}
+
+func TestReaderNetworks(t *testing.T) {
+	store := &restrictedStore{}
+	server := New(Config{ReaderBurnAfter: 2}, store, &nopLogger{})
+	send := func(method, addr, cidrs string) *httptest.ResponseRecorder {
+		body := ""
+		if method == http.MethodPut {
+			body = base64.StdEncoding.EncodeToString(append(make([]byte, 12), "abc"...))
+		}
+		request := httptest.NewRequest(method, "/message/abcdefgh", strings.NewReader(body))
+		request.RemoteAddr = addr + ":1234"
+		if cidrs != "" {
+			request.Header.Set("X-Reader-CIDRs", cidrs)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder
+	}
+	if recorder := send(http.MethodPut, "192.0.2.1", "10.8.0.0/16,not-a-cidr"); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad list, got %d", recorder.Code)
+	}
+	if recorder := send(http.MethodPut, "192.0.2.1", "10.8.0.0/16"); recorder.Code != http.StatusNoContent || store.policy.BurnAfter != 2 {
+		t.Fatalf("expected restricted attach, got %d", recorder.Code)
+	}
+	if recorder := send(http.MethodGet, "203.0.113.9", ""); recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), "reader_not_allowed") {
+		t.Fatalf("expected refusal outside the networks, got %d", recorder.Code)
+	}
+	if recorder := send(http.MethodGet, "10.8.1.1", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("expected read inside the networks, got %d", recorder.Code)
+	}
+
+	send(http.MethodPut, "192.0.2.1", "10.8.0.0/16")
+	send(http.MethodGet, "203.0.113.9", "")
+	send(http.MethodGet, "203.0.113.9", "")
+	if recorder := send(http.MethodGet, "10.8.1.1", ""); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected burn after repeated refusals, got %d", recorder.Code)
+	}
+}
+
+type banStore struct {
+	mockStore
+	counts map[string]int
+	bans   map[string]time.Duration
+}
+
+func (bs *banStore) Banned(_ context.Context, client string) (time.Duration, error) {
+	return bs.bans[client], nil
+}
+func (bs *banStore) RecordScan(_ context.Context, client string, kind string, p storage.ScanPolicy) (time.Duration, int, error) {
+	bs.counts[kind]++
+	if bs.counts[kind] < p.NotFoundLimit {
+		return 0, 0, nil
+	}
+	bs.bans[client] = p.Ban
+	return p.Ban, 1, nil
+}
+
+func TestScanDetectionBans(t *testing.T) {
+	store := &banStore{counts: map[string]int{}, bans: map[string]time.Duration{}}
+	var emitted []events.Event
+	sink := events.Func(func(_ context.Context, e events.Event) { emitted = append(emitted, e) })
+	server := New(Config{Scan: storage.ScanPolicy{NotFoundLimit: 3, ConflictLimit: 3, Ban: time.Minute}, Events: sink}, store, &nopLogger{})
+	get := func(path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.RemoteAddr = "203.0.113.7:1234"
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder
+	}
+	get("/health")
+	for i := 0; i < 3; i++ {
+		if recorder := get("/message/nope" + strconv.Itoa(i)); recorder.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", recorder.Code)
+		}
+	}
+	if store.counts[storage.ScanNotFound] != 3 {
+		t.Fatalf("expected only message 404s counted, got %v", store.counts)
+	}
+	if len(emitted) != 1 || emitted[0].Type != "client_banned" || emitted[0].Fields["client_ip"] != "203.0.113.7" {
+		t.Fatalf("expected a ban event, got %+v", emitted)
+	}
+	store.bans = map[string]time.Duration{}
+	recorder := get("/message/any")
+	if recorder.Code != http.StatusForbidden || recorder.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected cached ban, got %d %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+	if recorder := get("/health"); recorder.Code != http.StatusOK {
+		t.Fatalf("health checks must not be banned, got %d", recorder.Code)
+	}
+}
+
+func TestBanCacheBounded(t *testing.T) {
+	var c banCache
+	now := time.Now()
+	for i := 0; i < maxCachedBans; i++ {
+		c.put("expired-"+strconv.Itoa(i), now.Add(-time.Second))
+	}
+	c.put("live", now.Add(time.Hour))
+	if len(c.until) != 1 {
+		t.Fatalf("expected expired bans swept, got %d entries", len(c.until))
+	}
+	for i := 0; i < 2*maxCachedBans; i++ {
+		c.put("client-"+strconv.Itoa(i), now.Add(2*time.Hour+time.Duration(i)*time.Second))
+	}
+	if len(c.until) > maxCachedBans {
+		t.Fatalf("cache grew past its bound: %d", len(c.until))
+	}
+	if c.get("client-"+strconv.Itoa(2*maxCachedBans-1), now) <= 0 {
+		t.Fatalf("expected latest ban cached")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	server := New(Config{PlaceholderTTL: time.Minute, MessageTTL: time.Hour, Metrics: reg}, &mockStore{createOK: true}, &nopLogger{})
+	body := base64.StdEncoding.EncodeToString(append(make([]byte, 12), []byte("abc")...))
+	server.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body)))
+	server.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/message/secretcode", nil))
+	server.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("X-RANDOM-1234", "/health", nil))
+	done := server.StorageObserver().Observe(context.Background(), "GetAndDelete")
+	done(errors.New("boom"))
+
+	var b strings.Builder
+	reg.WriteTo(&b)
+	out := b.String()
+	for _, want := range []string{
+		`http_requests_total{route="/message",method="POST",status="201"} 1`,
+		`http_requests_total{route="/message/{code}",method="GET",status="404"} 1`,
+		`message_size_bytes_count{endpoint="message_post"} 1`,
+		`storage_operation_errors_total{method="GetAndDelete"} 1`,
+		`http_requests_total{route="/health",method="other",status="200"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secretcode") || strings.Contains(out, "X-RANDOM") {
+		t.Fatalf("codes must not leak into labels")
+	}
+}
+
+type spanRecorder []*trace.Span
+
+func (r *spanRecorder) Export(s *trace.Span) { *r = append(*r, s) }
+
+func TestTracing(t *testing.T) {
+	var spans spanRecorder
+	store := &mockStore{getVal: "secretcipher", getOK: true}
+	server := New(Config{Tracer: &trace.Tracer{Exporter: &spans, Ratio: 1}}, store, &nopLogger{})
+	store.observer = server.StorageObserver()
+
+	request := httptest.NewRequest(http.MethodGet, "/message/secretcode", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	if got := recorder.Header().Get("X-Request-Id"); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the trace ID as request ID, got %q", got)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected storage and request spans, got %d", len(spans))
+	}
+	child, root := spans[0], spans[1]
+	if root.Name != "GET /message/{code}" || root.Parent.String() != "00f067aa0ba902b7" || child.Parent != root.Context().SpanID {
+		t.Fatalf("unexpected span tree: %s <- %s", root.Name, child.Name)
+	}
+	for _, span := range spans {
+		for _, a := range span.Attrs() {
+			if v := fmt.Sprint(a.Value); strings.Contains(v, "secret") {
+				t.Fatalf("span %s leaks %s=%s", span.Name, a.Key, v)
+			}
+		}
+	}
+
+	spans = nil
+	server.cfg.Tracer.Ratio = 0
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if len(spans) != 0 || len(recorder.Header().Get("X-Request-Id")) != 32 {
+		t.Fatalf("unsampled requests must not be exported but still get an ID")
+	}
+}
+
+type lineLogger struct {
+	mu    sync.Mutex
+	lines []map[string]any
+}
+
+func (l *lineLogger) add(msg string, fields map[string]any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m := map[string]any{"msg": msg}
+	for k, v := range fields {
+		m[k] = v
+	}
+	l.lines = append(l.lines, m)
+}
+
+func (l *lineLogger) Debug(msg string, fields map[string]any) { l.add(msg, fields) }
+func (l *lineLogger) Info(msg string, fields map[string]any)  { l.add(msg, fields) }
+func (l *lineLogger) Warn(msg string, fields map[string]any)  { l.add(msg, fields) }
+func (l *lineLogger) Error(msg string, fields map[string]any) { l.add(msg, fields) }
+
+func TestAccessLog(t *testing.T) {
+	lg := &lineLogger{}
+	server := New(Config{AccessLog: true, AccessLogSuccessRate: 0}, &mockStore{getErr: errors.New("down")}, lg)
+	server.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if len(lg.lines) != 0 {
+		t.Fatalf("successful requests should be sampled out, got %v", lg.lines)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/message/secretcode", nil)
+	request.RemoteAddr = "203.0.113.7:1234"
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	if len(lg.lines) != 2 {
+		t.Fatalf("expected handler and access lines, got %v", lg.lines)
+	}
+	rid := recorder.Header().Get("X-Request-Id")
+	for _, line := range lg.lines {
+		if line["request_id"] != rid || line["route"] != "/message/{code}" || line["client_ip"] != "203.0.113.7" || line["method"] != "GET" {
+			t.Fatalf("missing request fields: %v", line)
+		}
+	}
+	access := lg.lines[1]
+	if access["msg"] != "access" || access["status"] != http.StatusInternalServerError || access["bytes"] == nil || access["duration_ms"] == nil {
+		t.Fatalf("unexpected access line: %v", access)
+	}
+}
+
+func TestInboundRequestID(t *testing.T) {
+	gateways, _ := netutil.ParseCIDRs([]string{"10.0.0.0/8"})
+	var emitted []events.Event
+	sink := events.Func(func(_ context.Context, e events.Event) { emitted = append(emitted, e) })
+	lg := &lineLogger{}
+	server := New(Config{RequestIDHeader: "X-Correlation-Id", RequestIDFrom: gateways, RequestIDFormat: reqid.UUIDv7, AccessLog: true, AccessLogSuccessRate: 1, Events: sink}, &mockStore{}, lg)
+	get := func(remote, id string) string {
+		request := httptest.NewRequest(http.MethodGet, "/health", nil)
+		request.RemoteAddr = remote
+		if id != "" {
+			request.Header.Set("X-Correlation-Id", id)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder.Header().Get("X-Correlation-Id")
+	}
+	if got := get("10.1.2.3:5000", "gw-42.abc"); got != "gw-42.abc" {
+		t.Fatalf("expected trusted inbound ID to be echoed, got %q", got)
+	}
+	if lg.lines[0]["request_id"] != "gw-42.abc" {
+		t.Fatalf("expected inbound ID in logs, got %v", lg.lines[0])
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7`)
+	if got := get("203.0.113.7:5000", "spoofed"); got == "spoofed" || !uuid.MatchString(got) {
+		t.Fatalf("expected untrusted ID to be replaced by a UUIDv7, got %q", got)
+	}
+	if got := get("10.1.2.3:5000", "bad id\r"); got == "bad id\r" || !uuid.MatchString(got) {
+		t.Fatalf("expected malformed ID to be replaced, got %q", got)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/health", nil)
+	server.emit(reqid.NewContext(request.Context(), "gw-7"), "test", map[string]any{})
+	if len(emitted) != 1 || emitted[0].Fields["request_id"] != "gw-7" {
+		t.Fatalf("expected events to carry the request ID, got %+v", emitted)
+	}
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	levels := applog.NewLevels(applog.Info)
+	server := New(Config{AdminToken: "secret", LogLevels: levels}, &mockStore{}, &nopLogger{})
+	call := func(method, token, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "/admin/log-level", strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder
+	}
+	if recorder := call(http.MethodGet, "wrong", ""); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", recorder.Code)
+	}
+	if recorder := call(http.MethodPut, "secret", `{"level":"debug"}`); recorder.Code != http.StatusOK || levels.Base() != applog.Debug {
+		t.Fatalf("expected base level change, got %d %s", recorder.Code, levels.Base())
+	}
+	if recorder := call(http.MethodPut, "secret", `{"component":"storage","level":"error"}`); recorder.Code != http.StatusOK || levels.Effective("storage") != applog.Error {
+		t.Fatalf("expected storage override, got %d", recorder.Code)
+	}
+	recorder := call(http.MethodGet, "secret", "")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"level":"debug","components":{"storage":"error"}`) {
+		t.Fatalf("unexpected state: %d %s", recorder.Code, recorder.Body.String())
+	}
+	for _, body := range []string{`{"level":"loud"}`, `{"component":"nope","level":"info"}`, `not json`} {
+		if recorder := call(http.MethodPut, "secret", body); recorder.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, recorder.Code)
+		}
+	}
+	if call(http.MethodPut, "secret", `{"component":"storage"}`); levels.Effective("storage") != applog.Debug {
+		t.Fatalf("expected override to be cleared")
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	af, err := audit.OpenFile(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer af.Close()
+	key := []byte("0123456789abcdef")
+	server := New(Config{PlaceholderTTL: time.Minute, MessageTTL: time.Hour, Audit: af, AuditKey: key}, &mockStore{createOK: true, getVal: "ct", getOK: true}, &nopLogger{})
+	body := base64.StdEncoding.EncodeToString(append(make([]byte, 12), []byte("abc")...))
+	request := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body))
+	request.RemoteAddr = "203.0.113.7:1234"
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	code := strings.TrimPrefix(recorder.Header().Get("Location"), "/message/")
+	request = httptest.NewRequest(http.MethodGet, "/message/"+code, nil)
+	recorder = httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	server.AuditExpired(context.Background(), "gone")
+
+	var entries []audit.Entry
+	af.Each(context.Background(), func(e audit.Entry) error { entries = append(entries, e); return nil })
+	h := audit.Hasher{Key: key}
+	if len(entries) != 3 || entries[0].Event != audit.Created || entries[1].Event != audit.Read || entries[2].Event != audit.Expired {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if entries[0].CodeHash != h.Code(code) || entries[1].CodeHash != h.Code(code) || entries[0].ClientHash != h.Client("203.0.113.7") {
+		t.Fatalf("entries do not identify the message and client by hash: %+v", entries[0])
+	}
+	if entries[1].RequestID != recorder.Header().Get("X-Request-Id") {
+		t.Fatalf("expected the request ID on the entry")
+	}
+	if n, _, err := audit.Verify(context.Background(), af); err != nil || n != 3 {
+		t.Fatalf("expected an intact chain, got %d %v", n, err)
+	}
+}
+
+func TestAuditPrefixBurn(t *testing.T) {
+	af, err := audit.OpenFile(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer af.Close()
+	key := []byte("0123456789abcdef")
+	store := &guessStore{burned: 3}
+	server := New(Config{GuessLimit: 10, GuessBurnAfter: 5, Audit: af, AuditKey: key}, store, &nopLogger{})
+	server.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/message/123456", nil))
+
+	var entries []audit.Entry
+	af.Each(context.Background(), func(e audit.Entry) error { entries = append(entries, e); return nil })
+	h := audit.Hasher{Key: key}
+	if len(entries) != 1 || entries[0].Event != audit.Burned || entries[0].Count != 3 || entries[0].PrefixHash != h.Prefix("12345") {
+		t.Fatalf("expected a burned entry for the prefix, got %+v", entries)
+	}
 }
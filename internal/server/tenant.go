@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend_msgs_golang/internal/codegen"
+	"backend_msgs_golang/internal/tenant"
+)
+
+// apiKey returns the key sent as X-Api-Key or as an "sk_" bearer token.
+func apiKey(r *http.Request) string {
+	if k := r.Header.Get("X-Api-Key"); k != "" {
+		return k
+	}
+	if tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(tok, "sk_") {
+		return tok
+	}
+	return ""
+}
+
+// authenticate resolves the caller's tenant into the request context. A
+// request without a key gets the anonymous tenant, if there is one; an
+// unknown key is refused.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if s.cfg.Tenants == nil || strings.HasPrefix(r.URL.Path, "/admin/") {
+		return r, true
+	}
+	key := apiKey(r)
+	if key == "" {
+		if s.cfg.AnonymousTenant != nil {
+			r = r.WithContext(tenant.NewContext(r.Context(), s.cfg.AnonymousTenant))
+		}
+		return r, true
+	}
+	t, ok, err := s.cfg.Tenants.LookupAPIKey(r.Context(), tenant.HashKey(key))
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("api_key_lookup_error", map[string]any{"endpoint": r.URL.Path})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return r, false
+	}
+	if !ok {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("invalid_api_key", map[string]any{"endpoint": r.URL.Path})
+		}
+		s.writeError(w, http.StatusUnauthorized, "invalid_api_key")
+		return r, false
+	}
+	return r.WithContext(tenant.NewContext(r.Context(), &t)), true
+}
+
+// keyedTenant returns the tenant behind the request's API key, if any.
+func (s *Server) keyedTenant(r *http.Request) (*tenant.Tenant, bool) {
+	t, ok := tenant.FromContext(r.Context())
+	if !ok || t == s.cfg.AnonymousTenant {
+		return nil, false
+	}
+	return t, true
+}
+
+// tenantGate enforces the tenant-level rules for creation requests.
+func (s *Server) tenantGate(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.Tenants == nil {
+		return true
+	}
+	t, ok := tenant.FromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, "api_key_required")
+		return false
+	}
+	if !t.OriginAllowed(r.Header.Get("Origin")) {
+		s.writeError(w, http.StatusForbidden, "origin_not_allowed")
+		return false
+	}
+	return true
+}
+
+// spendQuota charges n creations to the tenant's daily quota.
+func (s *Server) spendQuota(w http.ResponseWriter, r *http.Request, n int) bool {
+	t, ok := tenant.FromContext(r.Context())
+	if !ok || t.DailyQuota <= 0 || s.cfg.Tenants == nil {
+		return true
+	}
+	used, err := s.cfg.Tenants.AddQuota(r.Context(), t.ID, tenant.Day(time.Now()), int64(n))
+	if err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("quota_error", map[string]any{"endpoint": r.URL.Path})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if used > t.DailyQuota {
+		if s.log != nil {
+			s.logger(r.Context()).Warn("quota_exceeded", map[string]any{"endpoint": r.URL.Path, "tenant": t.ID})
+		}
+		s.refundQuota(r, n)
+		s.writeError(w, http.StatusTooManyRequests, "quota_exceeded")
+		return false
+	}
+	return true
+}
+
+// refundQuota gives back n creations charged by spendQuota that were not
+// stored, so a refused batch or a storage failure costs the tenant nothing.
+func (s *Server) refundQuota(r *http.Request, n int) {
+	t, ok := tenant.FromContext(r.Context())
+	if !ok || t.DailyQuota <= 0 || s.cfg.Tenants == nil || n <= 0 {
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	if _, err := s.cfg.Tenants.AddQuota(ctx, t.ID, tenant.Day(time.Now()), int64(-n)); err != nil && s.log != nil {
+		s.logger(ctx).Error("quota_refund_error", map[string]any{"endpoint": r.URL.Path, "tenant": t.ID})
+	}
+}
+
+func (s *Server) maxBody(r *http.Request) int64 {
+	if t, ok := tenant.FromContext(r.Context()); ok && t.MaxBodyBytes > 0 {
+		return t.MaxBodyBytes
+	}
+	if s.cfg.MaxBodyBytes > 0 {
+		return s.cfg.MaxBodyBytes
+	}
+	return 1 << 20
+}
+
+// ttlBounds returns the message TTL range allowed for the request; a zero
+// max means unbounded. When codes expire on their own, no tenant may keep a
+// message longer than its code stays valid.
+func (s *Server) ttlBounds(r *http.Request) (min, max time.Duration) {
+	max = s.cfg.MessageTTL
+	if t, ok := tenant.FromContext(r.Context()); ok {
+		if t.MaxTTL > 0 {
+			max = time.Duration(t.MaxTTL) * time.Second
+		}
+		if t.MinTTL > 0 {
+			min = time.Duration(t.MinTTL) * time.Second
+		}
+	}
+	if l := s.codeLifetime(); l > 0 && (max <= 0 || max > l) {
+		max = l
+	}
+	return min, max
+}
+
+// codeLifetime is how long a message may live under a code that carries
+// its own expiry, or zero. A reserved code may be attached up to
+// PlaceholderTTL after it was generated. main refuses to start when that
+// leaves nothing; should it happen anyway the clamp fails closed at one
+// second rather than lifting the cap.
+func (s *Server) codeLifetime() time.Duration {
+	e, ok := s.codes.(codegen.Expiring)
+	if !ok {
+		return 0
+	}
+	if l := e.Lifetime() - s.cfg.PlaceholderTTL; l > time.Second {
+		return l
+	}
+	return time.Second
+}
+
+func (s *Server) ttlAllowed(r *http.Request, ttl time.Duration) bool {
+	min, max := s.ttlBounds(r)
+	return ttl >= min && (max <= 0 || ttl <= max)
+}
+
+// messageTTL is the TTL asked for in X-Message-TTL (seconds), or the server
+// default clamped to the tenant's bounds. On failure the response has
+// already been written.
+func (s *Server) messageTTL(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	if v := r.Header.Get("X-Message-TTL"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		ttl := time.Duration(n) * time.Second
+		if err != nil || n <= 0 || !s.ttlAllowed(r, ttl) {
+			s.writeError(w, http.StatusBadRequest, "invalid_ttl")
+			return 0, false
+		}
+		return ttl, true
+	}
+	ttl := s.cfg.MessageTTL
+	min, max := s.ttlBounds(r)
+	if max > 0 && ttl > max {
+		ttl = max
+	}
+	if ttl < min {
+		ttl = min
+	}
+	return ttl, true
+}
+
+func (s *Server) adminAuthorized(r *http.Request) bool {
+	if s.cfg.AdminToken == "" {
+		return false
+	}
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(tok), []byte(s.cfg.AdminToken)) == 1
+}
+
+// provisionKey creates or updates the tenant in the body and issues a new
+// API key for it. The key is only ever shown in this response.
+func (s *Server) provisionKey(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.Tenants == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !s.adminAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var t tenant.Tenant
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil || t.ID == "" || strings.Contains(t.ID, ":") {
+		s.writeError(w, http.StatusBadRequest, "invalid_tenant")
+		return
+	}
+	key, err := tenant.NewKey()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ctx := r.Context()
+	if err := s.cfg.Tenants.SaveTenant(ctx, t); err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("save_tenant_error", map[string]any{"endpoint": "admin_keys"})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := s.cfg.Tenants.SaveAPIKey(ctx, tenant.HashKey(key), t.ID); err != nil {
+		if s.log != nil {
+			s.logger(r.Context()).Error("save_api_key_error", map[string]any{"endpoint": "admin_keys"})
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if s.log != nil {
+		s.logger(r.Context()).Info("api_key_provisioned", map[string]any{"tenant": t.ID})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"key": key, "tenant": t})
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"backend_msgs_golang/internal/trace"
+)
+
+// startSpan opens the server span for r, continuing the caller's trace when
+// it sent a valid traceparent. Only the route template is recorded, never
+// the path, so codes stay out of traces.
+func (s *Server) startSpan(r *http.Request) (*http.Request, *trace.Span) {
+	if s.cfg.Tracer == nil {
+		return r, nil
+	}
+	remote, _ := trace.ParseTraceparent(r.Header.Get("traceparent"))
+	route := routeLabel(r.URL.Path)
+	ctx, span := s.cfg.Tracer.Start(r.Context(), r.Method+" "+route, trace.KindServer, remote)
+	span.SetAttr("http.request.method", r.Method)
+	span.SetAttr("http.route", route)
+	return r.WithContext(ctx), span
+}
+
+func finishSpan(span *trace.Span, status int) {
+	span.SetAttr("http.response.status_code", status)
+	var err error
+	if status >= 500 {
+		err = fmt.Errorf("%d %s", status, http.StatusText(status))
+	}
+	span.Finish(err)
+}
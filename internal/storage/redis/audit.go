@@ -0,0 +1,146 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"backend_msgs_golang/internal/audit"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// AuditStream is an audit.Store on a Redis stream that is never trimmed.
+// The chain head ("seq:hash") lives in <stream>:head; appends from several
+// instances are serialised by watching it.
+type AuditStream struct {
+	client *redis.Client
+	store  *Store
+	Stream string
+}
+
+func (s *Store) AuditStream(stream string) *AuditStream {
+	return &AuditStream{client: s.client, store: s, Stream: stream}
+}
+
+func (as *AuditStream) headKey() string { return as.Stream + ":head" }
+
+func (as *AuditStream) Append(ctx context.Context, e *audit.Entry) (err error) {
+	defer as.store.observe(ctx, "AuditAppend")(&err)
+	for try := 0; try < 20; try++ {
+		err := as.client.Watch(ctx, func(tx *redis.Tx) error {
+			seq, prev, err := as.head(ctx, tx)
+			if err != nil {
+				return err
+			}
+			e.Link(seq+1, prev)
+			b, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				p.XAdd(ctx, &redis.XAddArgs{Stream: as.Stream, Values: []any{"entry", string(b)}})
+				p.Set(ctx, as.headKey(), strconv.FormatUint(e.Seq, 10)+":"+e.Hash, 0)
+				return nil
+			})
+			return err
+		}, as.headKey())
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return errors.New("redisstore: audit head kept changing")
+}
+
+// Head returns the sequence number and hash of the last entry appended.
+func (as *AuditStream) Head(ctx context.Context) (uint64, string, error) {
+	return as.head(ctx, as.client)
+}
+
+func (as *AuditStream) head(ctx context.Context, c redis.Cmdable) (uint64, string, error) {
+	v, err := c.Get(ctx, as.headKey()).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, audit.Genesis, nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	seqStr, hash, ok := strings.Cut(v, ":")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !ok || err != nil {
+		return 0, "", fmt.Errorf("redisstore: malformed audit head %q", v)
+	}
+	return seq, hash, nil
+}
+
+func (as *AuditStream) Each(ctx context.Context, fn func(audit.Entry) error) error {
+	start := "-"
+	for {
+		msgs, err := as.client.XRangeN(ctx, as.Stream, start, "+", 500).Result()
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			var e audit.Entry
+			raw, _ := m.Values["entry"].(string)
+			if err := json.Unmarshal([]byte(raw), &e); err != nil {
+				return fmt.Errorf("%w: stream entry %s is not an entry", audit.ErrBroken, m.ID)
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(msgs) < 500 {
+			return nil
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+}
+
+// EnableExpiryEvents turns on the keyspace notifications WatchExpired
+// needs, keeping any already configured. Managed Redis services often
+// refuse CONFIG; set notify-keyspace-events to "Ex" there instead.
+func (s *Store) EnableExpiryEvents(ctx context.Context) error {
+	cur, err := s.client.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+	flags := cur["notify-keyspace-events"]
+	if strings.Contains(flags, "E") && strings.ContainsAny(flags, "xA") {
+		return nil
+	}
+	for _, f := range "Ex" {
+		if !strings.ContainsRune(flags, f) {
+			flags += string(f)
+		}
+	}
+	return s.client.ConfigSet(ctx, "notify-keyspace-events", flags).Err()
+}
+
+// WatchExpired calls fn with the code of every message or placeholder Redis
+// expires, until ctx is done. Every instance watching receives every
+// expiry, so run it on one instance only.
+func (s *Store) WatchExpired(ctx context.Context, fn func(code string)) error {
+	sub := s.client.Subscribe(ctx, fmt.Sprintf("__keyevent@%d__:expired", s.client.Options().DB))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	msgs := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			if code, ok := strings.CutPrefix(m.Payload, "msg:"); ok {
+				fn(code)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+
+	"backend_msgs_golang/internal/events"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// StreamSink appends events to a Redis stream, trimmed to about MaxLen
+// entries, so that every instance's events can be consumed in one place.
+type StreamSink struct {
+	client *redis.Client
+	store  *Store
+	Stream string
+	MaxLen int64
+	// OnError is called when an event could not be written.
+	OnError func(error)
+}
+
+func (s *Store) EventStream(stream string, maxLen int64) *StreamSink {
+	return &StreamSink{client: s.client, store: s, Stream: stream, MaxLen: maxLen}
+}
+
+func (ss *StreamSink) Emit(ctx context.Context, e events.Event) {
+	var err error
+	defer ss.store.observe(ctx, "EmitEvent")(&err)
+	fields, _ := json.Marshal(e.Fields)
+	err = ss.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: ss.Stream,
+		MaxLen: ss.MaxLen,
+		Approx: true,
+		Values: []any{"type", e.Type, "time", e.Time.UnixMilli(), "fields", string(fields)},
+	}).Err()
+	if err != nil && ss.OnError != nil {
+		ss.OnError(err)
+	}
+}
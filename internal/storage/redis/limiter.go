@@ -0,0 +1,62 @@
+package redisstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"backend_msgs_golang/internal/ratelimit"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Limiter is a GCRA rate limiter shared by every instance using the same
+// Redis. The clock is the caller's, so instances should keep NTP time.
+type Limiter struct {
+	client *redis.Client
+	store  *Store
+	now    func() time.Time
+}
+
+func (s *Store) Limiter() *Limiter {
+	return &Limiter{client: s.client, store: s, now: time.Now}
+}
+
+var gcraScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local tolerance = interval * tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then tat = now end
+local newtat = tat + interval
+local allowAt = newtat - tolerance
+if allowAt > now then
+  return {0, 0, math.ceil(tat - now), math.ceil(allowAt - now)}
+end
+redis.call('SET', KEYS[1], string.format('%.0f', newtat), 'PX', math.ceil((newtat - now) / 1000))
+return {1, math.floor((now - allowAt) / interval), math.ceil(newtat - now), 0}
+`)
+
+func (l *Limiter) Allow(ctx context.Context, key string, rule ratelimit.Rule) (_ ratelimit.Result, err error) {
+	defer l.store.observe(ctx, "RateLimit")(&err)
+	burst := rule.Burst
+	if burst <= 0 {
+		burst = rule.RPS
+	}
+	// microseconds keep sub-millisecond intervals for high rates exact
+	interval := int64(time.Second/time.Microsecond) / int64(rule.RPS)
+	now := l.now().UnixMicro()
+	res, err := gcraScript.Run(ctx, l.client, []string{"rl:" + key},
+		strconv.FormatInt(now, 10), strconv.FormatInt(interval, 10), strconv.Itoa(burst),
+	).Int64Slice()
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	return ratelimit.Result{
+		Allowed:    res[0] == 1,
+		Limit:      burst,
+		Remaining:  int(res[1]),
+		Reset:      time.Duration(res[2]) * time.Microsecond,
+		RetryAfter: time.Duration(res[3]) * time.Microsecond,
+	}, nil
+}
@@ -0,0 +1,137 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend_msgs_golang/internal/storage"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Reader networks are kept in meta:<code> as one string of fixed-width
+// 16-byte hex ranges (first address then last), so the read script can
+// compare addresses as strings.
+// ARGV[5] is "create" for a fresh code or "attach" for a placeholder.
+var restrictScript = redis.NewScript(`
+if ARGV[5] == 'create' then
+  if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then return 0 end
+else
+  local v = redis.call('GET', KEYS[1])
+  if not v then return -1 end
+  if v ~= '' then return 0 end
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  redis.call('ZREM', KEYS[3], ARGV[6])
+end
+redis.call('DEL', KEYS[2])
+redis.call('HSET', KEYS[2], 'readers', ARGV[3], 'burn_after', ARGV[4])
+redis.call('PEXPIRE', KEYS[2], ARGV[2])
+return 1
+`)
+
+var readFromScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if not v then return {0} end
+if v == '' then
+  redis.call('DEL', KEYS[1])
+  redis.call('ZREM', KEYS[3], ARGV[2])
+  return {0}
+end
+if redis.call('HEXISTS', KEYS[2], 'reader_key') == 1 then return {0} end
+local ranges = redis.call('HGET', KEYS[2], 'readers')
+if ranges then
+  local ip = ARGV[1]
+  local allowed = false
+  if string.len(ip) == 32 then
+    for i = 1, string.len(ranges), 64 do
+      if ip >= string.sub(ranges, i, i + 31) and ip <= string.sub(ranges, i + 32, i + 63) then
+        allowed = true
+        break
+      end
+    end
+  end
+  if not allowed then
+    local n = redis.call('HINCRBY', KEYS[2], 'refused', 1)
+    local limit = tonumber(redis.call('HGET', KEYS[2], 'burn_after') or '0')
+    if limit > 0 and n >= limit then
+      redis.call('DEL', KEYS[1], KEYS[2])
+      return {3}
+    end
+    return {2}
+  end
+end
+local n = tonumber(redis.call('HGET', KEYS[2], 'views') or '1')
+if n and n > 1 then
+  redis.call('HINCRBY', KEYS[2], 'views', -1)
+  return {1, v}
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+return {1, v}
+`)
+
+func (s *Store) AttachRestrictedCipher(ctx context.Context, code string, ciphertext string, p storage.ReaderPolicy, ttl time.Duration) (ok bool, err error) {
+	defer s.observe(ctx, "AttachRestrictedCipher")(&err)
+	return s.restrict(ctx, "attach", code, ciphertext, p, ttl)
+}
+
+func (s *Store) CreateRestrictedCipher(ctx context.Context, code string, ciphertext string, p storage.ReaderPolicy, ttl time.Duration) (ok bool, err error) {
+	defer s.observe(ctx, "CreateRestrictedCipher")(&err)
+	return s.restrict(ctx, "create", code, ciphertext, p, ttl)
+}
+
+func (s *Store) restrict(ctx context.Context, mode string, code string, ciphertext string, p storage.ReaderPolicy, ttl time.Duration) (bool, error) {
+	keys := []string{"msg:" + code, "meta:" + code, "placeholders"}
+	res, err := restrictScript.Run(ctx, s.client, keys, ciphertext, strconv.FormatInt(ttl.Milliseconds(), 10), encodeRanges(p.Networks), strconv.Itoa(p.BurnAfter), mode, code).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+func (s *Store) ReadFrom(ctx context.Context, code string, client net.IP) (ct string, res storage.ReadResult, err error) {
+	defer s.observe(ctx, "ReadFrom")(&err)
+	ip := ""
+	if v := client.To16(); v != nil {
+		ip = hex.EncodeToString(v)
+	}
+	out, err := readFromScript.Run(ctx, s.client, []string{"msg:" + code, "meta:" + code, "placeholders"}, ip, code).Slice()
+	if err != nil {
+		return "", storage.ReadMissing, err
+	}
+	status, _ := out[0].(int64)
+	switch status {
+	case 1:
+		v, _ := out[1].(string)
+		return v, storage.ReadOK, nil
+	case 2:
+		return "", storage.ReadRefused, nil
+	case 3:
+		return "", storage.ReadBurned, nil
+	}
+	return "", storage.ReadMissing, nil
+}
+
+func encodeRanges(nets []*net.IPNet) string {
+	var b strings.Builder
+	for _, n := range nets {
+		first, mask := append(net.IP(nil), n.IP.To16()...), n.Mask
+		if len(first) != net.IPv6len {
+			continue
+		}
+		if len(mask) == net.IPv4len {
+			mask = append(net.CIDRMask(96, 128)[:12:12], mask...)
+		}
+		last := make(net.IP, net.IPv6len)
+		for i := range first {
+			first[i] &= mask[i]
+			last[i] = first[i] | ^mask[i]
+		}
+		b.WriteString(hex.EncodeToString(first))
+		b.WriteString(hex.EncodeToString(last))
+	}
+	return b.String()
+}
@@ -5,11 +5,28 @@ import (
 	"strconv"
 	"time"
 
+	"backend_msgs_golang/internal/storage"
+
 	redis "github.com/redis/go-redis/v9"
 )
 
 type Store struct {
-	client *redis.Client
+	client   *redis.Client
+	observer storage.Observer
+}
+
+// SetObserver reports every storage call made on behalf of a request to o,
+// for metrics and tracing. It must be called before the store is used.
+func (s *Store) SetObserver(o storage.Observer) { s.observer = o }
+
+// observe starts reporting an operation; use as
+// defer s.observe(ctx, "Op")(&err).
+func (s *Store) observe(ctx context.Context, op string) func(*error) {
+	if s.observer == nil {
+		return func(*error) {}
+	}
+	done := s.observer.Observe(ctx, op)
+	return func(err *error) { done(*err) }
 }
 
 func New(addr string) *Store {
@@ -22,26 +39,58 @@ func NewWithOptions(opts *redis.Options) *Store {
 	return &Store{client: c}
 }
 
-func (s *Store) ReserveCode(ctx context.Context, code string, ttl time.Duration) (bool, error) {
+// Placeholders are also indexed in the "placeholders" sorted set, scored
+// by expiry, so that they can be counted without scanning the keyspace.
+// Every reservation drops the members that have expired since, so the set
+// stays bounded by the live placeholders.
+var reserveScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[4])
+if not redis.call('SET', KEYS[1], '', 'NX', 'PX', ARGV[1]) then return 0 end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[3])
+return 1
+`)
+
+func (s *Store) ReserveCode(ctx context.Context, code string, ttl time.Duration) (ok bool, err error) {
+	defer s.observe(ctx, "ReserveCode")(&err)
 	key := "msg:" + code
-	ok, err := s.client.SetNX(ctx, key, "", ttl).Result()
+	now := time.Now()
+	expiry := now.Add(ttl).UnixMilli()
+	n, err := reserveScript.Run(ctx, s.client, []string{key, "placeholders"}, ttl.Milliseconds(), expiry, code, now.UnixMilli()).Int()
 	if err != nil {
 		return false, err
 	}
-	return ok, nil
+	return n == 1, nil
 }
 
-func (s *Store) AttachCipher(ctx context.Context, code string, ciphertext string, ttl time.Duration) (bool, error) {
+// ActivePlaceholders counts reserved codes still waiting for a message. It
+// runs on metrics scrapes, outside any request, so it is not observed.
+func (s *Store) ActivePlaceholders(ctx context.Context) (int64, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	var card *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZRemRangeByScore(ctx, "placeholders", "-inf", now)
+		card = p.ZCard(ctx, "placeholders")
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return card.Val(), nil
+}
+
+func (s *Store) AttachCipher(ctx context.Context, code string, ciphertext string, ttl time.Duration) (ok bool, err error) {
+	defer s.observe(ctx, "AttachCipher")(&err)
 	key := "msg:" + code
 	script := redis.NewScript(`
 local v = redis.call('GET', KEYS[1])
 if not v then return -1 end
 if v ~= '' then return 0 end
 redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+redis.call('ZREM', KEYS[2], ARGV[3])
 return 1
 `)
 	ttlSec := int(ttl / time.Second)
-	res, err := script.Run(ctx, s.client, []string{key}, ciphertext, strconv.Itoa(ttlSec)).Int()
+	res, err := script.Run(ctx, s.client, []string{key, "placeholders"}, ciphertext, strconv.Itoa(ttlSec), code).Int()
 	if err != nil {
 		return false, err
 	}
@@ -54,9 +103,33 @@ return 1
 	return false, nil
 }
 
-func (s *Store) GetAndDelete(ctx context.Context, code string) (string, bool, error) {
-	key := "msg:" + code
-	v, err := s.client.GetDel(ctx, key).Result()
+func (s *Store) CreateCipher(ctx context.Context, code string, ciphertext string, ttl time.Duration) (ok bool, err error) {
+	defer s.observe(ctx, "CreateCipher")(&err)
+	return s.client.SetNX(ctx, "msg:"+code, ciphertext, ttl).Result()
+}
+
+var readScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if not v then return false end
+if v == '' then
+  redis.call('DEL', KEYS[1])
+  redis.call('ZREM', KEYS[3], ARGV[1])
+  return false
+end
+if redis.call('HEXISTS', KEYS[2], 'readers') == 1 then return false end
+if redis.call('HEXISTS', KEYS[2], 'reader_key') == 1 then return false end
+local n = tonumber(redis.call('HGET', KEYS[2], 'views') or '1')
+if n and n > 1 then
+  redis.call('HINCRBY', KEYS[2], 'views', -1)
+  return v
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+return v
+`)
+
+func (s *Store) GetAndDelete(ctx context.Context, code string) (ct string, ok bool, err error) {
+	defer s.observe(ctx, "GetAndDelete")(&err)
+	v, err := readScript.Run(ctx, s.client, []string{"msg:" + code, "meta:" + code, "placeholders"}, code).Text()
 	if err == redis.Nil {
 		return "", false, nil
 	}
@@ -69,6 +142,256 @@ func (s *Store) GetAndDelete(ctx context.Context, code string) (string, bool, er
 	return v, true, nil
 }
 
-func (s *Store) Ping(ctx context.Context) error {
+const createScript = `
+local ok = redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2])
+if not ok then return 0 end
+if tonumber(ARGV[3]) > 1 then
+  redis.call('HSET', KEYS[2], 'views', ARGV[3])
+  redis.call('PEXPIRE', KEYS[2], ARGV[2])
+end
+return 1
+`
+
+func (s *Store) CreateCiphers(ctx context.Context, msgs []storage.NewMessage) (created []bool, err error) {
+	defer s.observe(ctx, "CreateCiphers")(&err)
+	cmds := make([]*redis.Cmd, len(msgs))
+	_, err = s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, m := range msgs {
+			keys := []string{"msg:" + m.Code, "meta:" + m.Code}
+			cmds[i] = p.Eval(ctx, createScript, keys, m.Ciphertext, strconv.FormatInt(m.TTL.Milliseconds(), 10), strconv.Itoa(m.Views))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]bool, len(msgs))
+	for i, c := range cmds {
+		n, err := c.Int()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = n == 1
+	}
+	return out, nil
+}
+
+func (s *Store) AttachBoundCipher(ctx context.Context, code string, ciphertext string, readerKey string, ttl time.Duration) (ok bool, err error) {
+	defer s.observe(ctx, "AttachBoundCipher")(&err)
+	script := redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if not v then return -1 end
+if v ~= '' then return 0 end
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+redis.call('ZREM', KEYS[3], ARGV[4])
+redis.call('DEL', KEYS[2])
+redis.call('HSET', KEYS[2], 'reader_key', ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[2])
+return 1
+`)
+	ttlSec := int(ttl / time.Second)
+	res, err := script.Run(ctx, s.client, []string{"msg:" + code, "meta:" + code, "placeholders"}, ciphertext, strconv.Itoa(ttlSec), readerKey, code).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+var createBoundScript = redis.NewScript(`
+if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then return 0 end
+redis.call('DEL', KEYS[2])
+redis.call('HSET', KEYS[2], 'reader_key', ARGV[3])
+redis.call('PEXPIRE', KEYS[2], ARGV[2])
+return 1
+`)
+
+// CreateBoundCipher stores a bound message under a fresh code; it reports
+// false when the code is already taken.
+func (s *Store) CreateBoundCipher(ctx context.Context, code string, ciphertext string, readerKey string, ttl time.Duration) (ok bool, err error) {
+	defer s.observe(ctx, "CreateBoundCipher")(&err)
+	n, err := createBoundScript.Run(ctx, s.client, []string{"msg:" + code, "meta:" + code}, ciphertext, ttl.Milliseconds(), readerKey).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *Store) ReaderKey(ctx context.Context, code string) (rk string, ok bool, err error) {
+	defer s.observe(ctx, "ReaderKey")(&err)
+	v, err := s.client.HGet(ctx, "meta:"+code, "reader_key").Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return v, v != "", nil
+}
+
+var claimScript = redis.NewScript(`
+if redis.call('HGET', KEYS[2], 'reader_key') ~= ARGV[1] then return false end
+local v = redis.call('GET', KEYS[1])
+if not v or v == '' then return false end
+redis.call('DEL', KEYS[1], KEYS[2])
+return v
+`)
+
+// ClaimBound reads and deletes a message still bound to readerKey. The
+// caller must have verified a proof of possession for that key.
+func (s *Store) ClaimBound(ctx context.Context, code string, readerKey string) (ct string, ok bool, err error) {
+	defer s.observe(ctx, "ClaimBound")(&err)
+	v, err := claimScript.Run(ctx, s.client, []string{"msg:" + code, "meta:" + code}, readerKey).Text()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+func (s *Store) PutChallenge(ctx context.Context, code string, nonce string, ttl time.Duration) (err error) {
+	defer s.observe(ctx, "PutChallenge")(&err)
+	return s.client.Set(ctx, "chal:"+code+":"+nonce, "1", ttl).Err()
+}
+
+func (s *Store) TakeChallenge(ctx context.Context, code string, nonce string) (ok bool, err error) {
+	defer s.observe(ctx, "TakeChallenge")(&err)
+	n, err := s.client.Del(ctx, "chal:"+code+":"+nonce).Result()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *Store) Exists(ctx context.Context, code string) (ok bool, err error) {
+	defer s.observe(ctx, "Exists")(&err)
+	v, err := s.client.Get(ctx, "msg:"+code).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return v != "", nil
+}
+
+func (s *Store) IndexCode(ctx context.Context, code string, prefix string, ttl time.Duration) (err error) {
+	defer s.observe(ctx, "IndexCode")(&err)
+	key := "pfx:" + prefix
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.SAdd(ctx, key, code)
+		p.PExpire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *Store) Lockout(ctx context.Context, client string) (lock time.Duration, err error) {
+	defer s.observe(ctx, "Lockout")(&err)
+	d, err := s.client.PTTL(ctx, "lock:ip:"+client).Result()
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, nil
+	}
+	return d, nil
+}
+
+// missScript counts a miss per client and per prefix. When the prefix count
+// reaches BurnAfter it only reports so; the codes to burn are read from the
+// prefix index and deleted by burnScript, which declares every key it
+// touches.
+var missScript = redis.NewScript(`
+local window = tonumber(ARGV[2])
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIRE', KEYS[1], window) end
+local lock = 0
+local limit = tonumber(ARGV[1])
+if limit > 0 and n >= limit then
+  lock = tonumber(ARGV[3]) * 2 ^ (n - limit)
+  if lock > tonumber(ARGV[4]) then lock = tonumber(ARGV[4]) end
+  lock = math.floor(lock)
+  if lock > 0 then
+    redis.call('SET', KEYS[2], '1', 'PX', lock)
+    redis.call('PEXPIRE', KEYS[1], math.max(window, lock))
+  end
+end
+local burn = 0
+local burnAfter = tonumber(ARGV[5])
+if burnAfter > 0 then
+  local m = redis.call('INCR', KEYS[3])
+  if m == 1 then redis.call('PEXPIRE', KEYS[3], window) end
+  if m >= burnAfter then burn = 1 end
+end
+return {lock, burn}
+`)
+
+// burnScript deletes the codes in ARGV; KEYS are the prefix counter, the
+// prefix index, the placeholder index, then msg: and meta: for each code.
+var burnScript = redis.NewScript(`
+local burned = 0
+for i, c in ipairs(ARGV) do
+  burned = burned + redis.call('DEL', KEYS[2 + 2 * i])
+  redis.call('DEL', KEYS[3 + 2 * i])
+  redis.call('SREM', KEYS[2], c)
+  redis.call('ZREM', KEYS[3], c)
+end
+redis.call('DEL', KEYS[1])
+return burned
+`)
+
+func (s *Store) RecordMiss(ctx context.Context, client string, prefix string, p storage.GuessPolicy) (lock time.Duration, burned int, err error) {
+	defer s.observe(ctx, "RecordMiss")(&err)
+	keys := []string{"guess:ip:" + client, "lock:ip:" + client, "guess:pfx:" + prefix}
+	window := p.Window
+	if window <= 0 {
+		window = 15 * time.Minute
+	}
+	maxLock := p.MaxLockout
+	if maxLock <= 0 {
+		maxLock = time.Hour
+	}
+	res, err := missScript.Run(ctx, s.client, keys,
+		p.Limit,
+		window.Milliseconds(),
+		p.Lockout.Milliseconds(),
+		maxLock.Milliseconds(),
+		p.BurnAfter,
+	).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	lock = time.Duration(res[0]) * time.Millisecond
+	if res[1] == 0 {
+		return lock, 0, nil
+	}
+	burned, err = s.burnPrefix(ctx, prefix)
+	return lock, burned, err
+}
+
+// burnPrefix deletes every code indexed under prefix, live or placeholder.
+func (s *Store) burnPrefix(ctx context.Context, prefix string) (int, error) {
+	codes, err := s.client.SMembers(ctx, "pfx:"+prefix).Result()
+	if err != nil {
+		return 0, err
+	}
+	keys := []string{"guess:pfx:" + prefix, "pfx:" + prefix, "placeholders"}
+	args := make([]any, len(codes))
+	for i, c := range codes {
+		keys = append(keys, "msg:"+c, "meta:"+c)
+		args[i] = c
+	}
+	return burnScript.Run(ctx, s.client, keys, args...).Int()
+}
+
+func (s *Store) Consume(ctx context.Context, id string, ttl time.Duration) (ok bool, err error) {
+	defer s.observe(ctx, "Consume")(&err)
+	return s.client.SetNX(ctx, "used:"+id, "1", ttl).Result()
+}
+
+func (s *Store) Ping(ctx context.Context) (err error) {
+    defer s.observe(ctx, "Ping")(&err)
     return s.client.Ping(ctx).Err()
 }
@@ -2,9 +2,18 @@ package redisstore
 
 import (
     "context"
+    "errors"
+    "net"
+    "strconv"
     "testing"
     "time"
 
+    "backend_msgs_golang/internal/audit"
+    "backend_msgs_golang/internal/netutil"
+    "backend_msgs_golang/internal/ratelimit"
+    "backend_msgs_golang/internal/storage"
+    "backend_msgs_golang/internal/tenant"
+
     miniredis "github.com/alicebob/miniredis/v2"
     redis "github.com/redis/go-redis/v9"
 )
@@ -35,3 +44,316 @@ func TestRedisStoreFlow(t *testing.T){
     if err := st.Ping(ctx); err != nil { t.Fatalf("ping failed: %v", err) }
 }
 
+func TestRedisStoreCreate(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+
+    ctx := context.Background()
+    ok, err := st.CreateCipher(ctx, "abc", "data", time.Minute)
+    if err != nil || !ok { t.Fatalf("create failed") }
+    ok, err = st.CreateCipher(ctx, "abc", "other", time.Minute)
+    if err != nil || ok { t.Fatalf("expected collision") }
+    ok, err = st.AttachCipher(ctx, "abc", "other", time.Minute)
+    if err != nil || ok { t.Fatalf("expected attach conflict") }
+
+    val, ok, err := st.GetAndDelete(ctx, "abc")
+    if err != nil || !ok || val != "data" { t.Fatalf("unexpected val: %s", val) }
+}
+
+func TestRedisStoreBound(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+
+    ctx := context.Background()
+    if ok, err := st.ReserveCode(ctx, "abc", time.Minute); err != nil || !ok { t.Fatalf("reserve failed") }
+    if ok, err := st.AttachBoundCipher(ctx, "abc", "data", "pub", time.Minute); err != nil || !ok { t.Fatalf("attach failed") }
+    if ok, err := st.AttachBoundCipher(ctx, "abc", "data", "pub", time.Minute); err != nil || ok { t.Fatalf("expected conflict") }
+
+    rk, ok, err := st.ReaderKey(ctx, "abc")
+    if err != nil || !ok || rk != "pub" { t.Fatalf("unexpected reader key: %q %v %v", rk, ok, err) }
+
+    if err := st.PutChallenge(ctx, "abc", "n1", time.Minute); err != nil { t.Fatal(err) }
+    if ok, _ := st.TakeChallenge(ctx, "abc", "n1"); !ok { t.Fatalf("challenge missing") }
+    if ok, _ := st.TakeChallenge(ctx, "abc", "n1"); ok { t.Fatalf("challenge reused") }
+
+    // unauthenticated reads never release a bound message
+    if _, ok, _ := st.GetAndDelete(ctx, "abc"); ok { t.Fatalf("getdel released a bound message") }
+    if _, res, _ := st.ReadFrom(ctx, "abc", net.ParseIP("10.0.0.1")); res != storage.ReadMissing { t.Fatalf("ReadFrom released a bound message: %v", res) }
+    if _, ok, _ := st.ClaimBound(ctx, "abc", "other"); ok { t.Fatalf("claimed with the wrong key") }
+    if !mr.Exists("msg:abc") { t.Fatalf("bound message deleted by a refused read") }
+    if v, ok, err := st.ClaimBound(ctx, "abc", "pub"); err != nil || !ok || v != "data" { t.Fatalf("claim failed: %q %v %v", v, ok, err) }
+    if _, ok, _ := st.ReaderKey(ctx, "abc"); ok { t.Fatalf("expected binding gone after claim") }
+    if _, ok, _ := st.ClaimBound(ctx, "abc", "pub"); ok { t.Fatalf("claimed twice") }
+
+    if ok, err := st.CreateBoundCipher(ctx, "def", "data", "pub", time.Minute); err != nil || !ok { t.Fatalf("create failed") }
+    if ok, err := st.CreateBoundCipher(ctx, "def", "other", "pub2", time.Minute); err != nil || ok { t.Fatalf("expected conflict") }
+    if rk, ok, _ := st.ReaderKey(ctx, "def"); !ok || rk != "pub" { t.Fatalf("unexpected reader key: %q", rk) }
+    if v, _ := mr.Get("msg:def"); v != "data" { t.Fatalf("unexpected ciphertext: %q", v) }
+}
+
+func TestRedisStoreBatchViews(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+
+    ctx := context.Background()
+    if ok, _ := st.ReserveCode(ctx, "taken", time.Minute); !ok { t.Fatalf("reserve failed") }
+    created, err := st.CreateCiphers(ctx, []storage.NewMessage{
+        {Code: "one", Ciphertext: "a", TTL: time.Minute, Views: 1},
+        {Code: "taken", Ciphertext: "b", TTL: time.Minute, Views: 1},
+        {Code: "two", Ciphertext: "c", TTL: time.Minute, Views: 2},
+    })
+    if err != nil { t.Fatal(err) }
+    if !created[0] || created[1] || !created[2] { t.Fatalf("unexpected results: %v", created) }
+
+    for i := 0; i < 2; i++ {
+        if v, ok, _ := st.GetAndDelete(ctx, "two"); !ok || v != "c" { t.Fatalf("read %d failed", i) }
+    }
+    if _, ok, _ := st.GetAndDelete(ctx, "two"); ok { t.Fatalf("expected burn after views") }
+}
+
+func TestRedisStoreGuessAccounting(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+
+    ctx := context.Background()
+    if ok, _ := st.CreateCipher(ctx, "123456", "data", time.Minute); !ok { t.Fatalf("create failed") }
+    if err := st.IndexCode(ctx, "123456", "12345", time.Minute); err != nil { t.Fatal(err) }
+    if ok, _ := st.ReserveCode(ctx, "123457", time.Minute); !ok { t.Fatalf("reserve failed") }
+    if err := st.IndexCode(ctx, "123457", "12345", time.Minute); err != nil { t.Fatal(err) }
+
+    p := storage.GuessPolicy{Limit: 2, Window: time.Minute, Lockout: time.Second, MaxLockout: 3 * time.Second, BurnAfter: 3}
+    lock, burned, err := st.RecordMiss(ctx, "1.2.3.4", "12345", p)
+    if err != nil || lock != 0 || burned != 0 { t.Fatalf("unexpected first miss: %v %d %v", lock, burned, err) }
+    lock, _, _ = st.RecordMiss(ctx, "1.2.3.4", "12345", p)
+    if lock != time.Second { t.Fatalf("expected 1s lockout, got %v", lock) }
+    if d, _ := st.Lockout(ctx, "1.2.3.4"); d <= 0 { t.Fatalf("expected active lockout") }
+    if d, _ := st.Lockout(ctx, "5.6.7.8"); d != 0 { t.Fatalf("unexpected lockout for other client") }
+
+    lock, burned, _ = st.RecordMiss(ctx, "5.6.7.8", "12345", p)
+    if burned != 2 { t.Fatalf("expected neighbour burn, got %d", burned) }
+    if lock != 0 { t.Fatalf("unexpected lockout %v", lock) }
+    if _, ok, _ := st.GetAndDelete(ctx, "123456"); ok { t.Fatalf("expected code burned") }
+    if n, _ := st.ActivePlaceholders(ctx); n != 0 { t.Fatalf("expected burned placeholder unindexed, got %d", n) }
+    if mr.Exists("pfx:12345") || mr.Exists("guess:pfx:12345") { t.Fatalf("expected prefix state cleared") }
+
+    for i := 0; i < 3; i++ { lock, _, _ = st.RecordMiss(ctx, "1.2.3.4", "99999", p) }
+    if lock != 3*time.Second { t.Fatalf("expected capped lockout, got %v", lock) }
+}
+
+func TestRedisLimiterGCRA(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    lim := NewWithOptions(&redis.Options{Addr: mr.Addr()}).Limiter()
+    now := time.Unix(1_700_000_000, 0)
+    lim.now = func() time.Time { return now }
+
+    ctx := context.Background()
+    rule := ratelimit.Rule{RPS: 2, Burst: 3}
+    for i := 0; i < 3; i++ {
+        res, err := lim.Allow(ctx, "k", rule)
+        if err != nil || !res.Allowed { t.Fatalf("request %d denied: %+v %v", i, res, err) }
+        if res.Remaining != 2-i { t.Fatalf("request %d: remaining %d", i, res.Remaining) }
+    }
+    res, err := lim.Allow(ctx, "k", rule)
+    if err != nil || res.Allowed { t.Fatalf("expected denial: %+v %v", res, err) }
+    if res.RetryAfter != 500*time.Millisecond { t.Fatalf("unexpected retry after %v", res.RetryAfter) }
+
+    now = now.Add(500 * time.Millisecond)
+    if res, _ := lim.Allow(ctx, "k", rule); !res.Allowed { t.Fatalf("expected refill") }
+    if res, _ := lim.Allow(ctx, "other", rule); !res.Allowed { t.Fatalf("other key limited") }
+}
+
+func TestRedisStoreTenants(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+
+    ctx := context.Background()
+    if err := st.SaveTenant(ctx, tenant.Tenant{ID: "acme", DailyQuota: 5}); err != nil { t.Fatal(err) }
+    if err := st.SaveAPIKey(ctx, tenant.HashKey("sk_x"), "acme"); err != nil { t.Fatal(err) }
+    tn, ok, err := st.LookupAPIKey(ctx, tenant.HashKey("sk_x"))
+    if err != nil || !ok || tn.ID != "acme" || tn.DailyQuota != 5 { t.Fatalf("lookup failed: %+v %v %v", tn, ok, err) }
+    if _, ok, _ := st.LookupAPIKey(ctx, tenant.HashKey("sk_y")); ok { t.Fatalf("unknown key found") }
+
+    st.AddQuota(ctx, "acme", "20260101", 2)
+    n, err := st.AddQuota(ctx, "acme", "20260101", 3)
+    if err != nil || n != 5 { t.Fatalf("unexpected quota %d %v", n, err) }
+}
+
+func TestRedisStoreReaderNetworks(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+
+    ctx := context.Background()
+    nets, _ := netutil.ParseCIDRs([]string{"10.8.0.0/16", "2001:db8::/32"})
+    policy := storage.ReaderPolicy{Networks: nets, BurnAfter: 3}
+    if ok, _ := st.ReserveCode(ctx, "vpn", time.Minute); !ok { t.Fatalf("reserve failed") }
+    if ok, err := st.AttachRestrictedCipher(ctx, "vpn", "data", policy, time.Minute); err != nil || !ok { t.Fatalf("attach failed: %v", err) }
+    if ok, _ := st.CreateRestrictedCipher(ctx, "vpn", "data", policy, time.Minute); ok { t.Fatalf("expected conflict") }
+
+    if _, ok, _ := st.GetAndDelete(ctx, "vpn"); ok { t.Fatalf("plain read must not bypass the restriction") }
+    if _, res, _ := st.ReadFrom(ctx, "vpn", net.ParseIP("10.9.0.1")); res != storage.ReadRefused { t.Fatalf("expected refusal, got %v", res) }
+    if _, res, _ := st.ReadFrom(ctx, "vpn", nil); res != storage.ReadRefused { t.Fatalf("expected refusal without an address, got %v", res) }
+    if v, res, _ := st.ReadFrom(ctx, "vpn", net.ParseIP("10.8.255.255")); res != storage.ReadOK || v != "data" { t.Fatalf("expected read from inside, got %v", res) }
+    if _, res, _ := st.ReadFrom(ctx, "vpn", net.ParseIP("10.8.0.1")); res != storage.ReadMissing { t.Fatalf("expected burn after read, got %v", res) }
+
+    if ok, _ := st.CreateRestrictedCipher(ctx, "v6", "data", policy, time.Minute); !ok { t.Fatalf("create failed") }
+    if _, res, _ := st.ReadFrom(ctx, "v6", net.ParseIP("2001:db9::1")); res != storage.ReadRefused { t.Fatalf("expected refusal, got %v", res) }
+    if _, res, _ := st.ReadFrom(ctx, "v6", net.ParseIP("10.0.0.1")); res != storage.ReadRefused { t.Fatalf("expected refusal, got %v", res) }
+    if _, res, _ := st.ReadFrom(ctx, "v6", net.ParseIP("::ffff:10.7.0.1")); res != storage.ReadBurned { t.Fatalf("expected burn on third refusal, got %v", res) }
+    if mr.Exists("msg:v6") { t.Fatalf("expected message gone") }
+
+    if ok, _ := st.CreateCipher(ctx, "open", "data", time.Minute); !ok { t.Fatalf("create failed") }
+    if _, res, _ := st.ReadFrom(ctx, "open", net.ParseIP("192.0.2.1")); res != storage.ReadOK { t.Fatalf("unrestricted messages read anywhere, got %v", res) }
+}
+
+func TestRedisStoreScanBans(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+
+    ctx := context.Background()
+    p := storage.ScanPolicy{NotFoundLimit: 3, ConflictLimit: 2, Window: time.Minute, Ban: time.Minute, MaxBan: 3 * time.Minute}
+    for i := 0; i < 2; i++ {
+        if ban, _, _ := st.RecordScan(ctx, "1.2.3.4", storage.ScanNotFound, p); ban != 0 { t.Fatalf("banned too early") }
+    }
+    ban, offence, err := st.RecordScan(ctx, "1.2.3.4", storage.ScanNotFound, p)
+    if err != nil || ban != time.Minute || offence != 1 { t.Fatalf("expected first ban, got %v %d %v", ban, offence, err) }
+    if d, _ := st.Banned(ctx, "1.2.3.4"); d <= 0 { t.Fatalf("expected ban stored") }
+    if d, _ := st.Banned(ctx, "5.6.7.8"); d != 0 { t.Fatalf("unexpected ban") }
+
+    mr.FastForward(2 * time.Minute)
+    if d, _ := st.Banned(ctx, "1.2.3.4"); d != 0 { t.Fatalf("expected ban expired") }
+    st.RecordScan(ctx, "1.2.3.4", storage.ScanConflict, p)
+    if ban, offence, _ = st.RecordScan(ctx, "1.2.3.4", storage.ScanConflict, p); ban != 2*time.Minute || offence != 2 { t.Fatalf("expected escalated ban, got %v %d", ban, offence) }
+    mr.FastForward(3 * time.Minute)
+    st.RecordScan(ctx, "1.2.3.4", storage.ScanConflict, p)
+    if ban, _, _ = st.RecordScan(ctx, "1.2.3.4", storage.ScanConflict, p); ban != 3*time.Minute { t.Fatalf("expected capped ban, got %v", ban) }
+}
+
+type opRecorder []string
+
+func (o *opRecorder) Observe(_ context.Context, op string) func(error) {
+    return func(err error) {
+        if err != nil { op += ":error" }
+        *o = append(*o, op)
+    }
+}
+
+func TestRedisStorePlaceholdersAndObserver(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+    ops := &opRecorder{}
+    st.SetObserver(ops)
+
+    ctx := context.Background()
+    for _, c := range []string{"a", "b", "c"} {
+        if ok, _ := st.ReserveCode(ctx, c, time.Minute); !ok { t.Fatalf("reserve failed") }
+    }
+    if ok, _ := st.ReserveCode(ctx, "a", time.Minute); ok { t.Fatalf("expected collision") }
+    st.AttachCipher(ctx, "a", "data", time.Minute)
+    st.GetAndDelete(ctx, "b")
+    if n, err := st.ActivePlaceholders(ctx); err != nil || n != 1 { t.Fatalf("expected 1 placeholder, got %d %v", n, err) }
+
+    if len(*ops) != 6 || (*ops)[0] != "ReserveCode" || (*ops)[4] != "AttachCipher" || (*ops)[5] != "GetAndDelete" { t.Fatalf("unexpected operations %v", *ops) }
+    mr.Close()
+    st.Ping(ctx)
+    if last := (*ops)[len(*ops)-1]; last != "Ping:error" { t.Fatalf("expected failed ping reported, got %s", last) }
+}
+
+func TestRedisStoreObservesEveryCall(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+    ops := &opRecorder{}
+    st.SetObserver(ops)
+
+    ctx := context.Background()
+    st.Exists(ctx, "a")
+    st.ReaderKey(ctx, "a")
+    st.Consume(ctx, "id", time.Minute)
+    st.Lockout(ctx, "1.2.3.4")
+    st.Banned(ctx, "1.2.3.4")
+    st.AddQuota(ctx, "acme", "20260101", 1)
+    st.Limiter().Allow(ctx, "k", ratelimit.Rule{RPS: 1})
+    want := []string{"Exists", "ReaderKey", "Consume", "Lockout", "Banned", "AddQuota", "RateLimit"}
+    if len(*ops) != len(want) { t.Fatalf("unexpected operations %v", *ops) }
+    for i, op := range want {
+        if (*ops)[i] != op { t.Fatalf("unexpected operations %v", *ops) }
+    }
+}
+
+func TestRedisStoreReservePrunesPlaceholders(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+
+    ctx := context.Background()
+    mr.ZAdd("placeholders", float64(time.Now().Add(-time.Minute).UnixMilli()), "gone")
+    if ok, _ := st.ReserveCode(ctx, "a", time.Minute); !ok { t.Fatalf("reserve failed") }
+    members, _ := mr.ZMembers("placeholders")
+    if len(members) != 1 || members[0] != "a" { t.Fatalf("expected expired placeholder pruned, got %v", members) }
+}
+
+func TestRedisStoreAuditStream(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+    as := st.AuditStream("audit")
+
+    ctx := context.Background()
+    h := audit.Hasher{Key: []byte("k")}
+    for i := 0; i < 1203; i++ {
+        if err := as.Append(ctx, &audit.Entry{Time: time.Now(), Event: audit.Created, CodeHash: h.Code(strconv.Itoa(i))}); err != nil { t.Fatal(err) }
+    }
+    n, head, err := audit.Verify(ctx, as)
+    if err != nil || n != 1203 { t.Fatalf("expected intact chain, got %d %v", n, err) }
+    if seq, h, _ := as.Head(ctx); seq != n || h != head { t.Fatalf("head key out of step with the stream") }
+
+    msgs, _ := st.client.XRangeN(ctx, "audit", "-", "+", 2).Result()
+    st.client.XDel(ctx, "audit", msgs[1].ID)
+    if _, _, err := audit.Verify(ctx, as); !errors.Is(err, audit.ErrBroken) { t.Fatalf("expected deleted entry to break the chain, got %v", err) }
+}
+
+func TestRedisStoreWatchExpired(t *testing.T){
+    mr, err := miniredis.Run()
+    if err != nil { t.Fatal(err) }
+    defer mr.Close()
+    st := NewWithOptions(&redis.Options{Addr: mr.Addr()})
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    codes := make(chan string, 2)
+    go st.WatchExpired(ctx, func(code string) { codes <- code })
+    deadline := time.Now().Add(2 * time.Second)
+    for len(mr.PubSubChannels("__keyevent@0__:expired")) == 0 {
+        if time.Now().After(deadline) { t.Fatalf("watcher never subscribed") }
+        time.Sleep(5 * time.Millisecond)
+    }
+    mr.Publish("__keyevent@0__:expired", "rl:whatever")
+    mr.Publish("__keyevent@0__:expired", "msg:abc")
+    select {
+    case code := <-codes:
+        if code != "abc" { t.Fatalf("unexpected code %q", code) }
+    case <-time.After(2 * time.Second):
+        t.Fatalf("expiry not reported")
+    }
+}
@@ -0,0 +1,78 @@
+package redisstore
+
+import (
+	"context"
+	"time"
+
+	"backend_msgs_golang/internal/storage"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+func (s *Store) Banned(ctx context.Context, client string) (ban time.Duration, err error) {
+	defer s.observe(ctx, "Banned")(&err)
+	d, err := s.client.PTTL(ctx, "ban:"+client).Result()
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, nil
+	}
+	return d, nil
+}
+
+// scanScript counts one suspicious response. Reaching the limit within the
+// window records an offence and bans the client for Ban doubled per
+// earlier offence, capped at MaxBan.
+var scanScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[2]) end
+if n < tonumber(ARGV[1]) then return {0, 0} end
+redis.call('DEL', KEYS[1])
+local o = redis.call('INCR', KEYS[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[5])
+local ban = tonumber(ARGV[3]) * 2 ^ math.min(o - 1, 40)
+if ban > tonumber(ARGV[4]) then ban = tonumber(ARGV[4]) end
+ban = math.floor(ban)
+redis.call('SET', KEYS[3], o, 'PX', ban)
+return {ban, o}
+`)
+
+func (s *Store) RecordScan(ctx context.Context, client string, kind string, p storage.ScanPolicy) (ban time.Duration, offence int, err error) {
+	defer s.observe(ctx, "RecordScan")(&err)
+	limit := p.NotFoundLimit
+	if kind == storage.ScanConflict {
+		limit = p.ConflictLimit
+	}
+	if limit <= 0 {
+		return 0, 0, nil
+	}
+	window, ban, maxBan, memory := p.Window, p.Ban, p.MaxBan, p.OffenceWindow
+	if window <= 0 {
+		window = time.Minute
+	}
+	if ban <= 0 {
+		ban = 15 * time.Minute
+	}
+	if maxBan <= 0 {
+		maxBan = 24 * time.Hour
+	}
+	if maxBan < ban {
+		maxBan = ban
+	}
+	if memory <= 0 {
+		memory = 7 * 24 * time.Hour
+	}
+	keys := []string{"scan:" + kind + ":" + client, "offence:" + client, "ban:" + client}
+	res, err := scanScript.Run(ctx, s.client, keys,
+		limit,
+		window.Milliseconds(),
+		ban.Milliseconds(),
+		maxBan.Milliseconds(),
+		memory.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return time.Duration(res[0]) * time.Millisecond, int(res[1]), nil
+}
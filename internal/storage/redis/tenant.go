@@ -0,0 +1,62 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"backend_msgs_golang/internal/tenant"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+func (s *Store) SaveTenant(ctx context.Context, t tenant.Tenant) (err error) {
+	defer s.observe(ctx, "SaveTenant")(&err)
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, "tenant:"+t.ID, b, 0).Err()
+}
+
+func (s *Store) SaveAPIKey(ctx context.Context, keyHash string, tenantID string) (err error) {
+	defer s.observe(ctx, "SaveAPIKey")(&err)
+	return s.client.Set(ctx, "apikey:"+keyHash, tenantID, 0).Err()
+}
+
+func (s *Store) LookupAPIKey(ctx context.Context, keyHash string) (t tenant.Tenant, ok bool, err error) {
+	defer s.observe(ctx, "LookupAPIKey")(&err)
+	id, err := s.client.Get(ctx, "apikey:"+keyHash).Result()
+	if err == redis.Nil {
+		return tenant.Tenant{}, false, nil
+	}
+	if err != nil {
+		return tenant.Tenant{}, false, err
+	}
+	b, err := s.client.Get(ctx, "tenant:"+id).Bytes()
+	if err == redis.Nil {
+		return tenant.Tenant{}, false, nil
+	}
+	if err != nil {
+		return tenant.Tenant{}, false, err
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return tenant.Tenant{}, false, err
+	}
+	return t, true, nil
+}
+
+func (s *Store) AddQuota(ctx context.Context, tenantID string, day string, n int64) (used int64, err error) {
+	defer s.observe(ctx, "AddQuota")(&err)
+	key := "quota:" + tenantID + ":" + day
+	var incr *redis.IntCmd
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		incr = p.IncrBy(ctx, key, n)
+		p.Expire(ctx, key, 48*time.Hour)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
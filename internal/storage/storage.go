@@ -2,12 +2,144 @@ package storage
 
 import (
 	"context"
+	"net"
 	"time"
 )
 
 type Storage interface {
 	ReserveCode(ctx context.Context, code string, ttl time.Duration) (bool, error)
 	AttachCipher(ctx context.Context, code string, ciphertext string, ttl time.Duration) (bool, error)
+	CreateCipher(ctx context.Context, code string, ciphertext string, ttl time.Duration) (bool, error)
 	GetAndDelete(ctx context.Context, code string) (string, bool, error)
 	Ping(ctx context.Context) error
 }
+
+// ChallengeStore keeps short-lived single-use nonces issued for a code.
+type ChallengeStore interface {
+	PutChallenge(ctx context.Context, code string, nonce string, ttl time.Duration) error
+	TakeChallenge(ctx context.Context, code string, nonce string) (bool, error)
+}
+
+// BoundStore binds a message to a reader public key; the server only
+// releases it after a valid proof of possession. GetAndDelete and ReadFrom
+// never release a bound message: ClaimBound is the only way to read it, and
+// only while it is still bound to readerKey.
+type BoundStore interface {
+	ChallengeStore
+	AttachBoundCipher(ctx context.Context, code string, ciphertext string, readerKey string, ttl time.Duration) (bool, error)
+	CreateBoundCipher(ctx context.Context, code string, ciphertext string, readerKey string, ttl time.Duration) (bool, error)
+	ReaderKey(ctx context.Context, code string) (string, bool, error)
+	ClaimBound(ctx context.Context, code string, readerKey string) (string, bool, error)
+}
+
+// Peeker reports whether a message is attached without consuming it.
+type Peeker interface {
+	Exists(ctx context.Context, code string) (bool, error)
+}
+
+// NewMessage is one entry of a batch creation. Views above 1 lets the
+// message be read that many times before it is burned.
+type NewMessage struct {
+	Code       string
+	Ciphertext string
+	TTL        time.Duration
+	Views      int
+}
+
+// BatchStore creates many messages in a single round trip. The result holds
+// one flag per message, false when its code was already taken.
+type BatchStore interface {
+	CreateCiphers(ctx context.Context, msgs []NewMessage) ([]bool, error)
+}
+
+// GuessPolicy tunes how failed lookups are accounted for.
+type GuessPolicy struct {
+	Limit      int           // misses per client before it is locked out
+	Window     time.Duration // how long misses are remembered
+	Lockout    time.Duration // first lockout, doubled on every further miss
+	MaxLockout time.Duration
+	BurnAfter  int // misses on one prefix before the codes under it are burned
+}
+
+// GuessStore throttles enumeration of short codes. Codes are indexed by
+// prefix so that a burst of wrong guesses around a live code burns it.
+type GuessStore interface {
+	IndexCode(ctx context.Context, code string, prefix string, ttl time.Duration) error
+	Lockout(ctx context.Context, client string) (time.Duration, error)
+	RecordMiss(ctx context.Context, client string, prefix string, p GuessPolicy) (lock time.Duration, burned int, err error)
+}
+
+// ReplayStore remembers single-use tokens. Consume reports false when id was
+// already consumed within ttl.
+type ReplayStore interface {
+	Consume(ctx context.Context, id string, ttl time.Duration) (bool, error)
+}
+
+// ReaderPolicy limits the client addresses a message may be read from.
+type ReaderPolicy struct {
+	Networks  []*net.IPNet
+	BurnAfter int // refused reads before the message is burned; 0 never burns
+}
+
+type ReadResult int
+
+const (
+	ReadOK ReadResult = iota
+	ReadMissing
+	ReadRefused // the client is outside the reader networks; nothing was deleted
+	ReadBurned  // the refusal reached BurnAfter and the message was deleted
+)
+
+// RestrictedStore keeps a ReaderPolicy next to the ciphertext. ReadFrom
+// checks it in the same atomic step as the read and otherwise behaves like
+// GetAndDelete.
+type RestrictedStore interface {
+	AttachRestrictedCipher(ctx context.Context, code string, ciphertext string, p ReaderPolicy, ttl time.Duration) (bool, error)
+	CreateRestrictedCipher(ctx context.Context, code string, ciphertext string, p ReaderPolicy, ttl time.Duration) (bool, error)
+	ReadFrom(ctx context.Context, code string, client net.IP) (string, ReadResult, error)
+}
+
+// Kinds of responses counted by a BanStore.
+const (
+	ScanNotFound = "nf" // GET of a code that does not exist
+	ScanConflict = "cf" // PUT to a code that is not an open placeholder
+)
+
+// ScanPolicy tunes the detection of clients sweeping the code space.
+type ScanPolicy struct {
+	NotFoundLimit int // 404s on reads within Window before a ban; 0 off
+	ConflictLimit int // 409s on attaches within Window before a ban; 0 off
+	Window        time.Duration
+	Ban           time.Duration // first ban, doubled for every earlier offence
+	MaxBan        time.Duration
+	OffenceWindow time.Duration // how long offences count towards escalation
+}
+
+// BanStore keeps temporary client bans shared by every instance.
+type BanStore interface {
+	Banned(ctx context.Context, client string) (time.Duration, error)
+	// RecordScan counts one response of kind and returns the ban it
+	// triggered, if any, with the client's offence number.
+	RecordScan(ctx context.Context, client string, kind string, p ScanPolicy) (ban time.Duration, offence int, err error)
+}
+
+// Observer is told about storage operations as they start; the returned
+// function is called with the operation's outcome when it ends.
+type Observer interface {
+	Observe(ctx context.Context, op string) func(err error)
+}
+
+// Observers fans an operation out to several observers.
+type Observers []Observer
+
+func (os Observers) Observe(ctx context.Context, op string) func(err error) {
+	done := make([]func(error), len(os))
+	for i, o := range os {
+		done[i] = o.Observe(ctx, op)
+	}
+	return func(err error) {
+		for _, d := range done {
+			d(err)
+		}
+	}
+}
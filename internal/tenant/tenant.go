@@ -0,0 +1,73 @@
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// Tenant is the policy attached to an API key. Zero values fall back to the
+// server defaults.
+type Tenant struct {
+	ID             string   `json:"id"`
+	MaxBodyBytes   int64    `json:"max_body_bytes,omitempty"`
+	MinTTL         int64    `json:"min_ttl,omitempty"` // seconds
+	MaxTTL         int64    `json:"max_ttl,omitempty"` // seconds
+	RateRPS        int      `json:"rate_rps,omitempty"`
+	RateBurst      int      `json:"rate_burst,omitempty"`
+	DailyQuota     int64    `json:"daily_quota,omitempty"`
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+}
+
+// Store persists tenants and API keys; keys are only ever stored hashed.
+type Store interface {
+	SaveTenant(ctx context.Context, t Tenant) error
+	SaveAPIKey(ctx context.Context, keyHash string, tenantID string) error
+	LookupAPIKey(ctx context.Context, keyHash string) (Tenant, bool, error)
+	// AddQuota adds n to the tenant's usage for day and returns the total.
+	AddQuota(ctx context.Context, tenantID string, day string, n int64) (int64, error)
+}
+
+func NewKey() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return "sk_" + base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
+
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Day is the quota bucket for t, in UTC.
+func Day(t time.Time) string {
+	return t.UTC().Format("20060102")
+}
+
+func (t *Tenant) OriginAllowed(origin string) bool {
+	if len(t.AllowedOrigins) == 0 || origin == "" {
+		return true
+	}
+	for _, o := range t.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+func FromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(ctxKey{}).(*Tenant)
+	return t, ok
+}
@@ -0,0 +1,207 @@
+package tlsconf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options mirrors the TLS_* environment variables.
+type Options struct {
+	CertFile   string
+	KeyFile    string
+	MinVersion string   // "1.2" (default) or "1.3"
+	Ciphers    []string // Go cipher suite names; empty keeps Go's defaults
+	ClientCA   string   // PEM bundle used to verify client certificates
+	ClientAuth string   // none, request, verify_if_given or require
+}
+
+// New builds a server TLS config whose certificate is served by the
+// returned Reloader.
+func New(o Options) (*tls.Config, *Reloader, error) {
+	rl, err := NewReloader(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	min, err := ParseVersion(o.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := &tls.Config{MinVersion: min, GetCertificate: rl.GetCertificate}
+	if len(o.Ciphers) > 0 {
+		if cfg.CipherSuites, err = ParseCiphers(o.Ciphers); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.ClientAuth, err = ParseClientAuth(o.ClientAuth, o.ClientCA != ""); err != nil {
+		return nil, nil, err
+	}
+	if o.ClientCA != "" {
+		pem, err := os.ReadFile(o.ClientCA)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("tlsconf: no certificates in %s", o.ClientCA)
+		}
+		cfg.ClientCAs = pool
+	}
+	return cfg, rl, nil
+}
+
+func ParseVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("tlsconf: unsupported minimum version %q", v)
+}
+
+// ParseCiphers maps suite names such as TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+// to IDs. Insecure suites are refused. TLS 1.3 suites are not configurable.
+func ParseCiphers(names []string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, c := range tls.CipherSuites() {
+		known[c.Name] = c.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, n := range names {
+		id, ok := known[strings.TrimSpace(n)]
+		if !ok {
+			return nil, fmt.Errorf("tlsconf: unknown or insecure cipher suite %q", n)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseClientAuth defaults to require-and-verify when a CA bundle is given.
+func ParseClientAuth(mode string, haveCA bool) (tls.ClientAuthType, error) {
+	switch mode {
+	case "":
+		if haveCA {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	case "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "verify_if_given":
+		if !haveCA {
+			return 0, errors.New("tlsconf: verify_if_given needs a client CA")
+		}
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		if !haveCA {
+			return 0, errors.New("tlsconf: require needs a client CA")
+		}
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return 0, fmt.Errorf("tlsconf: unknown client auth mode %q", mode)
+}
+
+// Reloader serves a certificate pair that can be swapped without a restart,
+// either on demand (SIGHUP) or when the files change on disk.
+type Reloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tlsconf: certificate and key files are required")
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the pair from disk. A broken pair keeps the current one.
+func (r *Reloader) Reload() error {
+	mt := r.latestModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, mt
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch polls the files every interval and reloads them after a change,
+// reporting every attempt to onReload, until ctx is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, onReload func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.mu.RLock()
+			seen := r.modTime
+			r.mu.RUnlock()
+			if !r.latestModTime().After(seen) {
+				continue
+			}
+			err := r.Reload()
+			if err != nil {
+				// Retry only after the next change.
+				r.mu.Lock()
+				r.modTime = r.latestModTime()
+				r.mu.Unlock()
+			}
+			if onReload != nil {
+				onReload(err)
+			}
+		}
+	}
+}
+
+func (r *Reloader) latestModTime() time.Time {
+	var mt time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(mt) {
+			mt = fi.ModTime()
+		}
+	}
+	return mt
+}
+
+// PeerIdentity returns the subject common name of a verified client
+// certificate, or the first DNS name when the CN is empty.
+func PeerIdentity(cs *tls.ConnectionState) (string, bool) {
+	if cs == nil || len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	leaf := cs.VerifiedChains[0][0]
+	if leaf.Subject.CommonName != "" {
+		return leaf.Subject.CommonName, true
+	}
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0], true
+	}
+	return "", false
+}
@@ -0,0 +1,164 @@
+package tlsconf
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type pair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func issue(t *testing.T, cn string, parent *pair, client bool) *pair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signer, signKey := tpl, key
+	if parent == nil {
+		tpl.IsCA, tpl.BasicConstraintsValid = true, true
+		tpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signKey = parent.cert, parent.key
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		if client {
+			tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &pair{cert: cert, key: key, der: der}
+}
+
+func (p *pair) write(t *testing.T, dir, name string) (string, string) {
+	keyDER, _ := x509.MarshalECPrivateKey(p.key)
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func (p *pair) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{p.der}, PrivateKey: p.key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "test-ca", nil, false)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issue(t, "server", ca, false).write(t, dir, "server")
+
+	cfg, _, err := New(Options{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3", ClientCA: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := PeerIdentity(r.TLS)
+		io.WriteString(w, id)
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.Listener = tls.NewListener(srv.Listener, cfg)
+	srv.Start()
+	defer srv.Close()
+	url := "https://" + srv.Listener.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (string, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := c.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b), nil
+	}
+	if _, err := get(); err == nil {
+		t.Fatalf("expected handshake failure without a client certificate")
+	}
+	if id, err := get(issue(t, "ops-runner", ca, true).tlsCert()); err != nil || id != "ops-runner" {
+		t.Fatalf("expected client identity, got %q %v", id, err)
+	}
+	if _, err := get(issue(t, "intruder", issue(t, "other-ca", nil, false), true).tlsCert()); err == nil {
+		t.Fatalf("expected handshake failure for a foreign client certificate")
+	}
+}
+
+func TestReloaderPicksUpNewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "test-ca", nil, false)
+	certFile, keyFile := issue(t, "first", ca, false).write(t, dir, "server")
+	rl, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan error, 4)
+	go rl.Watch(ctx, 10*time.Millisecond, func(err error) { reloaded <- err })
+
+	issue(t, "second", ca, false).write(t, dir, "server")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+	// The watcher may catch the pair half written; it retries on the
+	// next change.
+	deadline := time.After(2 * time.Second)
+	for done := false; !done; {
+		select {
+		case err := <-reloaded:
+			done = err == nil
+		case <-deadline:
+			t.Fatalf("certificate change not noticed")
+		}
+	}
+	c, _ := rl.GetCertificate(nil)
+	leaf, _ := x509.ParseCertificate(c.Certificate[0])
+	if leaf.Subject.CommonName != "second" {
+		t.Fatalf("expected reloaded certificate, got %s", leaf.Subject.CommonName)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	if _, err := ParseCiphers([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Fatalf("insecure suite accepted")
+	}
+	if ids, err := ParseCiphers([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}); err != nil || len(ids) != 1 {
+		t.Fatalf("expected one suite, got %v %v", ids, err)
+	}
+	if _, err := ParseVersion("1.0"); err == nil {
+		t.Fatalf("TLS 1.0 accepted")
+	}
+	if _, err := ParseClientAuth("require", false); err == nil {
+		t.Fatalf("require accepted without a CA")
+	}
+}
@@ -0,0 +1,187 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// OTLPExporter batches spans and posts them as OTLP/HTTP JSON to Endpoint,
+// e.g. http://collector:4318/v1/traces. Spans are dropped, and counted in
+// Dropped, when the queue is full rather than slowing requests down.
+type OTLPExporter struct {
+	Endpoint  string
+	Service   string
+	Headers   map[string]string
+	Client    *http.Client
+	BatchSize int
+	Interval  time.Duration
+	OnError   func(error)
+	Dropped   atomic.Uint64
+
+	queue chan *Span
+}
+
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint:  endpoint,
+		Service:   service,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		BatchSize: 512,
+		Interval:  5 * time.Second,
+		queue:     make(chan *Span, 4096),
+	}
+}
+
+func (e *OTLPExporter) Export(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+		e.Dropped.Add(1)
+	}
+}
+
+// Run sends batches until ctx is done, then flushes what is queued.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	tick := time.NewTicker(e.Interval)
+	defer tick.Stop()
+	var batch []*Span
+	send := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.Send(ctx, batch); err != nil && e.OnError != nil {
+			e.OnError(err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) >= e.BatchSize {
+				send(ctx)
+			}
+		case <-tick.C:
+			send(ctx)
+		case <-ctx.Done():
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			fctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			send(fctx)
+			cancel()
+			return
+		}
+	}
+}
+
+// Send posts one batch.
+func (e *OTLPExporter) Send(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("trace: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string      `json:"traceId"`
+	SpanID       string      `json:"spanId"`
+	ParentSpanID string      `json:"parentSpanId,omitempty"`
+	Name         string      `json:"name"`
+	Kind         Kind        `json:"kind"`
+	Start        string      `json:"startTimeUnixNano"`
+	End          string      `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr  `json:"attributes,omitempty"`
+	Status       *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *OTLPExporter) encode(spans []*Span) map[string]any {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID: s.sc.TraceID.String(),
+			SpanID:  s.sc.SpanID.String(),
+			Name:    s.Name,
+			Kind:    s.Kind,
+			Start:   strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.Parent.IsValid() {
+			o.ParentSpanID = s.Parent.String()
+		}
+		for _, a := range s.Attrs() {
+			o.Attributes = append(o.Attributes, otlpAttr{a.Key, value(a.Value)})
+		}
+		if s.Err != nil {
+			o.Status = &otlpStatus{Code: 2, Message: s.Err.Error()}
+		}
+		out = append(out, o)
+	}
+	return map[string]any{"resourceSpans": []any{map[string]any{
+		"resource": map[string]any{"attributes": []otlpAttr{{"service.name", value(e.Service)}}},
+		"scopeSpans": []any{map[string]any{
+			"scope": map[string]string{"name": "backend_msgs_golang"},
+			"spans": out,
+		}},
+	}}}
+}
+
+func value(v any) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	}
+	s := fmt.Sprint(v)
+	return otlpValue{StringValue: &s}
+}
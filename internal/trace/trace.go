@@ -0,0 +1,202 @@
+// Package trace records request spans and exports them over OTLP/HTTP. It
+// implements the small part of OpenTelemetry the server needs: W3C
+// traceparent propagation, parent-based ratio sampling and batched export.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (t TraceID) IsValid() bool  { return t != TraceID{} }
+
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// ParseTraceparent reads a W3C traceparent header
+// ("00-<trace id>-<parent id>-<flags>"). Unknown future versions are read
+// by their first four fields, as the specification asks.
+func ParseTraceparent(h string) (SpanContext, bool) {
+	var sc SpanContext
+	f := strings.Split(strings.TrimSpace(h), "-")
+	if len(f) < 4 || len(f[0]) != 2 || f[0] == "ff" || (f[0] == "00" && len(f) != 4) {
+		return sc, false
+	}
+	if len(f[1]) != 32 || len(f[2]) != 16 || len(f[3]) != 2 || !lowerHex(f[0]+f[1]+f[2]+f[3]) {
+		return sc, false
+	}
+	hex.Decode(sc.TraceID[:], []byte(f[1]))
+	hex.Decode(sc.SpanID[:], []byte(f[2]))
+	var flags [1]byte
+	hex.Decode(flags[:], []byte(f[3]))
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+func lowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Span kinds, numbered as in OTLP.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+type Attr struct {
+	Key   string
+	Value any // string, bool, int, int64 or float64
+}
+
+// Span is one timed operation. A nil *Span ignores every call, so callers
+// need not check whether tracing is on.
+type Span struct {
+	Name   string
+	Kind   Kind
+	Parent SpanID
+	Start  time.Time
+	End    time.Time
+	Err    error
+
+	sc     SpanContext
+	tracer *Tracer
+
+	mu    sync.Mutex
+	attrs []Attr
+	ended bool
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// Recording reports whether the span is sampled and will be exported.
+func (s *Span) Recording() bool { return s != nil && s.sc.Sampled }
+
+func (s *Span) SetAttr(key string, value any) {
+	if !s.Recording() {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, Attr{key, value})
+	s.mu.Unlock()
+}
+
+func (s *Span) Attrs() []Attr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Attr(nil), s.attrs...)
+}
+
+// Finish ends the span, marking it failed when err is not nil, and hands
+// sampled spans to the exporter. Later calls do nothing.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.End, s.Err = true, time.Now(), err
+	s.mu.Unlock()
+	if s.sc.Sampled && s.tracer.Exporter != nil {
+		s.tracer.Exporter.Export(s)
+	}
+}
+
+// Exporter receives finished, sampled spans. Export must not block.
+type Exporter interface {
+	Export(*Span)
+}
+
+// Tracer starts spans. Ratio is the share of new traces sampled (0 to 1);
+// traces continued from a traceparent keep the caller's decision. A nil
+// *Tracer starts no spans.
+type Tracer struct {
+	Exporter Exporter
+	Ratio    float64
+}
+
+// Start begins a span under the span in ctx, or under remote when ctx
+// carries none and remote is valid, or else a new trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, remote SpanContext) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := remote
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.sc
+	}
+	s := &Span{Name: name, Kind: kind, Start: time.Now(), tracer: t}
+	if parent.IsValid() {
+		s.sc.TraceID, s.Parent, s.sc.Sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	rand.Read(s.sc.SpanID[:])
+	return ContextWithSpan(ctx, s), s
+}
+
+// sample compares the trace ID's low 8 bytes with the ratio, so every
+// service using the same ratio agrees on the same traces.
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.Ratio >= 1:
+		return true
+	case t.Ratio <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:]) < uint64(t.Ratio*(1<<63))*2
+}
+
+type ctxKey struct{}
+
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, ctxKey{}, s)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(ctxKey{}).(*Span)
+	return s
+}
@@ -0,0 +1,118 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTraceparent(t *testing.T) {
+	const h = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(h)
+	if !ok || !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected parse: %+v %v", sc, ok)
+	}
+	if sc.Traceparent() != h {
+		t.Fatalf("round trip: %s", sc.Traceparent())
+	}
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Fatalf("accepted %q", bad)
+		}
+	}
+	if _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); !ok {
+		t.Fatalf("future versions should parse")
+	}
+}
+
+type recorder []*Span
+
+func (r *recorder) Export(s *Span) { *r = append(*r, s) }
+
+func TestSampling(t *testing.T) {
+	var got recorder
+	tr := &Tracer{Exporter: &got, Ratio: 0}
+	_, s := tr.Start(context.Background(), "root", KindServer, SpanContext{})
+	s.Finish(nil)
+	if len(got) != 0 || s.Recording() {
+		t.Fatalf("ratio 0 must not sample new traces")
+	}
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, s := tr.Start(context.Background(), "root", KindServer, parent)
+	_, child := tr.Start(ctx, "child", KindClient, SpanContext{})
+	child.SetAttr("db.operation", "Ping")
+	child.Finish(errors.New("boom"))
+	s.Finish(nil)
+	if len(got) != 2 || s.Context().TraceID != parent.TraceID || s.Parent != parent.SpanID || child.Parent != s.Context().SpanID {
+		t.Fatalf("expected sampled parent to be followed, got %d spans", len(got))
+	}
+
+	tr.Ratio = 0.5
+	n := 0
+	for i := 0; i < 2000; i++ {
+		if _, s := tr.Start(context.Background(), "r", KindServer, SpanContext{}); s.Recording() {
+			n++
+		}
+	}
+	if n < 800 || n > 1200 {
+		t.Fatalf("ratio 0.5 sampled %d of 2000", n)
+	}
+}
+
+func TestOTLPExport(t *testing.T) {
+	bodies := make(chan map[string]any, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Token") != "t" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var m map[string]any
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &m)
+		bodies <- m
+	}))
+	defer collector.Close()
+
+	exp := NewOTLPExporter(collector.URL+"/v1/traces", "msgs")
+	exp.Headers = map[string]string{"X-Token": "t"}
+	exp.Interval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { exp.Run(ctx); close(done) }()
+
+	tr := &Tracer{Exporter: exp, Ratio: 1}
+	_, s := tr.Start(context.Background(), "GET /health", KindServer, SpanContext{})
+	s.SetAttr("http.response.status_code", 200)
+	s.Finish(nil)
+
+	var body map[string]any
+	select {
+	case body = <-bodies:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("collector received nothing")
+	}
+	cancel()
+	<-done
+	rs := body["resourceSpans"].([]any)[0].(map[string]any)
+	span := rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+	if span["traceId"] != s.Context().TraceID.String() || span["name"] != "GET /health" || span["kind"] != float64(KindServer) {
+		t.Fatalf("unexpected span: %v", span)
+	}
+	attr := span["attributes"].([]any)[0].(map[string]any)
+	if attr["value"].(map[string]any)["intValue"] != "200" {
+		t.Fatalf("unexpected attribute: %v", attr)
+	}
+}